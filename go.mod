@@ -11,10 +11,16 @@ require (
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/go-ldap/ldap/v3 v3.2.1
 	github.com/golang/mock v1.4.3
-	github.com/nmcclain/asn1-ber v0.0.0-20170104154839-2661553a0484 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/nmcclain/asn1-ber v0.0.0-20170104154839-2661553a0484
 	github.com/nmcclain/ldap v0.0.0-20191021200707-3b3b69a7e9e3
+	github.com/pquerna/otp v1.2.0
 	github.com/stretchr/testify v1.6.1
 	github.com/unknwon/com v1.0.1
 	github.com/urfave/cli/v2 v2.2.0
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	gopkg.in/ini.v1 v1.52.0
+	xorm.io/builder v0.3.7
+	xorm.io/xorm v1.0.1
 )
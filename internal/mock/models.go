@@ -33,6 +33,125 @@ func (m *MockModels) EXPECT() *MockModelsMockRecorder {
 	return m.recorder
 }
 
+// CheckPasswordPolicy mocks base method
+func (m *MockModels) CheckPasswordPolicy(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckPasswordPolicy", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckPasswordPolicy indicates an expected call of CheckPasswordPolicy
+func (mr *MockModelsMockRecorder) CheckPasswordPolicy(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckPasswordPolicy", reflect.TypeOf((*MockModels)(nil).CheckPasswordPolicy), arg0)
+}
+
+// GetAccessTokenBySHA mocks base method
+func (m *MockModels) GetAccessTokenBySHA(arg0 string) (*models.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccessTokenBySHA", arg0)
+	ret0, _ := ret[0].(*models.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccessTokenBySHA indicates an expected call of GetAccessTokenBySHA
+func (mr *MockModelsMockRecorder) GetAccessTokenBySHA(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccessTokenBySHA", reflect.TypeOf((*MockModels)(nil).GetAccessTokenBySHA), arg0)
+}
+
+// GetOrgUsersByOrgID mocks base method
+func (m *MockModels) GetOrgUsersByOrgID(arg0 *models.FindOrgMembersOpts) ([]*models.OrgUser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrgUsersByOrgID", arg0)
+	ret0, _ := ret[0].([]*models.OrgUser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrgUsersByOrgID indicates an expected call of GetOrgUsersByOrgID
+func (mr *MockModelsMockRecorder) GetOrgUsersByOrgID(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrgUsersByOrgID", reflect.TypeOf((*MockModels)(nil).GetOrgUsersByOrgID), arg0)
+}
+
+// GetRepoUsersByAccessMode mocks base method
+func (m *MockModels) GetRepoUsersByAccessMode(arg0 *models.Repository, arg1 models.AccessMode) ([]*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRepoUsersByAccessMode", arg0, arg1)
+	ret0, _ := ret[0].([]*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRepoUsersByAccessMode indicates an expected call of GetRepoUsersByAccessMode
+func (mr *MockModelsMockRecorder) GetRepoUsersByAccessMode(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRepoUsersByAccessMode", reflect.TypeOf((*MockModels)(nil).GetRepoUsersByAccessMode), arg0, arg1)
+}
+
+// GetTeam mocks base method
+func (m *MockModels) GetTeam(arg0 int64, arg1 string) (*models.Team, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTeam", arg0, arg1)
+	ret0, _ := ret[0].(*models.Team)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTeam indicates an expected call of GetTeam
+func (mr *MockModelsMockRecorder) GetTeam(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeam", reflect.TypeOf((*MockModels)(nil).GetTeam), arg0, arg1)
+}
+
+// GetTeamMembers mocks base method
+func (m *MockModels) GetTeamMembers(arg0 int64) ([]*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTeamMembers", arg0)
+	ret0, _ := ret[0].([]*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTeamMembers indicates an expected call of GetTeamMembers
+func (mr *MockModelsMockRecorder) GetTeamMembers(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTeamMembers", reflect.TypeOf((*MockModels)(nil).GetTeamMembers), arg0)
+}
+
+// GetTwoFactorByUID mocks base method
+func (m *MockModels) GetTwoFactorByUID(arg0 int64) (*models.TwoFactor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTwoFactorByUID", arg0)
+	ret0, _ := ret[0].(*models.TwoFactor)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTwoFactorByUID indicates an expected call of GetTwoFactorByUID
+func (mr *MockModelsMockRecorder) GetTwoFactorByUID(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTwoFactorByUID", reflect.TypeOf((*MockModels)(nil).GetTwoFactorByUID), arg0)
+}
+
+// GetUserByName mocks base method
+func (m *MockModels) GetUserByName(arg0 string) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByName", arg0)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByName indicates an expected call of GetUserByName
+func (mr *MockModelsMockRecorder) GetUserByName(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByName", reflect.TypeOf((*MockModels)(nil).GetUserByName), arg0)
+}
+
 // GetUserTeams mocks base method
 func (m *MockModels) GetUserTeams(arg0 int64, arg1 models.ListOptions) ([]*models.Team, error) {
 	m.ctrl.T.Helper()
@@ -48,6 +167,68 @@ func (mr *MockModelsMockRecorder) GetUserTeams(arg0, arg1 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTeams", reflect.TypeOf((*MockModels)(nil).GetUserTeams), arg0, arg1)
 }
 
+// GetUsersByIDs mocks base method
+func (m *MockModels) GetUsersByIDs(arg0 []int64) (models.UserList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersByIDs", arg0)
+	ret0, _ := ret[0].(models.UserList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersByIDs indicates an expected call of GetUsersByIDs
+func (mr *MockModelsMockRecorder) GetUsersByIDs(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersByIDs", reflect.TypeOf((*MockModels)(nil).GetUsersByIDs), arg0)
+}
+
+// ListPublicKeys mocks base method
+func (m *MockModels) ListPublicKeys(arg0 int64, arg1 models.ListOptions) ([]*models.PublicKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPublicKeys", arg0, arg1)
+	ret0, _ := ret[0].([]*models.PublicKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPublicKeys indicates an expected call of ListPublicKeys
+func (mr *MockModelsMockRecorder) ListPublicKeys(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPublicKeys", reflect.TypeOf((*MockModels)(nil).ListPublicKeys), arg0, arg1)
+}
+
+// SearchRepository mocks base method
+func (m *MockModels) SearchRepository(arg0 *models.SearchRepoOptions) (models.RepositoryList, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchRepository", arg0)
+	ret0, _ := ret[0].(models.RepositoryList)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SearchRepository indicates an expected call of SearchRepository
+func (mr *MockModelsMockRecorder) SearchRepository(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchRepository", reflect.TypeOf((*MockModels)(nil).SearchRepository), arg0)
+}
+
+// SearchTeam mocks base method
+func (m *MockModels) SearchTeam(arg0 *models.SearchTeamOptions) ([]*models.Team, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchTeam", arg0)
+	ret0, _ := ret[0].([]*models.Team)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SearchTeam indicates an expected call of SearchTeam
+func (mr *MockModelsMockRecorder) SearchTeam(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchTeam", reflect.TypeOf((*MockModels)(nil).SearchTeam), arg0)
+}
+
 // SearchUsers mocks base method
 func (m *MockModels) SearchUsers(arg0 *models.SearchUserOptions) ([]*models.User, int64, error) {
 	m.ctrl.T.Helper()
@@ -64,6 +245,34 @@ func (mr *MockModelsMockRecorder) SearchUsers(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUsers", reflect.TypeOf((*MockModels)(nil).SearchUsers), arg0)
 }
 
+// UpdateTwoFactor mocks base method
+func (m *MockModels) UpdateTwoFactor(arg0 *models.TwoFactor) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTwoFactor", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateTwoFactor indicates an expected call of UpdateTwoFactor
+func (mr *MockModelsMockRecorder) UpdateTwoFactor(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTwoFactor", reflect.TypeOf((*MockModels)(nil).UpdateTwoFactor), arg0)
+}
+
+// UpdateUserPassword mocks base method
+func (m *MockModels) UpdateUserPassword(arg0 *models.User, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUserPassword", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateUserPassword indicates an expected call of UpdateUserPassword
+func (mr *MockModelsMockRecorder) UpdateUserPassword(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserPassword", reflect.TypeOf((*MockModels)(nil).UpdateUserPassword), arg0, arg1)
+}
+
 // UserSignIn mocks base method
 func (m *MockModels) UserSignIn(arg0, arg1 string) (*models.User, error) {
 	m.ctrl.T.Helper()
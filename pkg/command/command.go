@@ -2,18 +2,17 @@ package command
 
 import (
 	"context"
-	"fmt"
 
-	"github.com/rucciva/giteaty/pkg/gitea/globals"
 	cli "github.com/urfave/cli/v2"
 )
 
 func action(c *cli.Context) (err error) {
-	if err = initDB(c); err != nil {
-		return fmt.Errorf("init database failed: %v", err)
+	mdl, err := initModels(c)
+	if err != nil {
+		return
 	}
 
-	return startLDAP(c, globals.Models())
+	return startLDAP(c, mdl)
 }
 
 func Run(ctx context.Context, args []string) (err error) {
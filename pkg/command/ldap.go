@@ -1,19 +1,58 @@
 package command
 
 import (
+	"context"
+	"net/http"
+
 	"git.rucciva.one/rucciva/log"
-	ldaps "github.com/nmcclain/ldap"
 	"github.com/rucciva/giteaty/pkg/gitea"
 	"github.com/rucciva/giteaty/pkg/ldaphandler"
+	"github.com/rucciva/giteaty/pkg/ldapserver"
+	"github.com/rucciva/giteaty/pkg/rediscache"
 	"github.com/urfave/cli/v2"
 )
 
 const (
 	flagLDAPBaseDn            = "ldap-base-dn"
 	flagLDAPSearchers         = "ldap-searchers"
+	flagLDAPProfile           = "ldap-profile"
+	flagLDAPUserAttribute     = "ldap-user-attribute"
+	flagLDAPGroupAttribute    = "ldap-group-attribute"
+	flagLDAPAttributeMapping  = "ldap-attribute-mapping"
+	flagLDAPServiceAccounts   = "ldap-service-accounts"
+	flagLDAPACL               = "ldap-acl"
+	flagLDAPSelfSearch        = "ldap-self-search"
+	flagLDAPSelfSearchGroups  = "ldap-self-search-groups"
+	flagLDAPPasswordModify    = "ldap-password-modify"
+	flagLDAPTokenOnly         = "ldap-token-only"
+	flagLDAPOTP               = "ldap-otp"
+	flagLDAPRequireOTP        = "ldap-require-otp"
 	flagLDAPCacheSize         = "ldap-cache-size"
 	flagLDAPCacheExpireSecond = "ldap-cache-expire-second"
+	flagLDAPCacheStaleSecond  = "ldap-cache-stale-second"
+	flagLDAPCacheRefresh      = "ldap-cache-refresh-second"
+	flagLDAPCacheRedisAddr    = "ldap-cache-redis-addr"
+	flagLDAPCacheRedisPass    = "ldap-cache-redis-password"
+	flagLDAPCacheRedisDB      = "ldap-cache-redis-db"
+	flagLDAPCacheRedisPrefix  = "ldap-cache-redis-prefix"
+	flagLDAPWebhookAddr       = "ldap-webhook-listen-addr"
+	flagLDAPWebhookSecret     = "ldap-webhook-secret"
 	flagLDAPListenAddr        = "ldap-listen-addr"
+	flagLDAPTLSListenAddr     = "ldap-tls-listen-addr"
+	flagLDAPTLSCert           = "ldap-tls-cert"
+	flagLDAPTLSKey            = "ldap-tls-key"
+	flagLDAPRequireTLS        = "ldap-require-tls"
+	flagLDAPPosix             = "ldap-posix"
+	flagLDAPPosixIDOffset     = "ldap-posix-id-offset"
+	flagLDAPPosixTeamIDOffset = "ldap-posix-team-id-offset"
+	flagLDAPPosixHomeDir      = "ldap-posix-home-directory"
+	flagLDAPPosixLoginShell   = "ldap-posix-login-shell"
+	flagLDAPSSHPublicKeys     = "ldap-ssh-public-keys"
+	flagLDAPExcludeDeployKeys = "ldap-exclude-deploy-keys"
+	flagLDAPRepoGroups        = "ldap-repo-groups"
+	flagLDAPRepoAccessGroups  = "ldap-repo-groups-per-access-level"
+	flagLDAPAdminGroup        = "ldap-admin-group"
+	flagLDAPUserFlags         = "ldap-user-flags"
 )
 
 func ldapFlag() []cli.Flag {
@@ -29,6 +68,68 @@ func ldapFlag() []cli.Flag {
 			Usage:   "gitea usernames allowed for ldap searching",
 			Value:   cli.NewStringSlice("admin"),
 		},
+		&cli.StringFlag{
+			Name:    flagLDAPProfile,
+			EnvVars: []string{"LDAP_PROFILE"},
+			Usage:   "schema profile of users and groups, either 'inetorgperson' or 'ad' for Active Directory compatible attributes",
+			Value:   "inetorgperson",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPUserAttribute,
+			EnvVars: []string{"LDAP_USER_ATTRIBUTE"},
+			Usage:   "attribute used as RDN of users, defaults to uid, or cn for the ad profile",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPGroupAttribute,
+			EnvVars: []string{"LDAP_GROUP_ATTRIBUTE"},
+			Usage:   "attribute used as RDN of groups",
+			Value:   "cn",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPAttributeMapping,
+			EnvVars: []string{"LDAP_ATTRIBUTE_MAPPING"},
+			Usage:   "path to JSON file of attribute templates, copies, and renames of users and groups",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPServiceAccounts,
+			EnvVars: []string{"LDAP_SERVICE_ACCOUNTS"},
+			Usage:   "path to file of 'name:hash' lines, with bcrypt or argon2 hash, of service accounts allowed to search",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPACL,
+			EnvVars: []string{"LDAP_ACL"},
+			Usage:   "path to JSON file of access control rules, e.g. [{\"users\": true, \"self\": true}] let users read their own entry",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPSelfSearch,
+			EnvVars: []string{"LDAP_SELF_SEARCH"},
+			Usage:   "allow bound gitea users to do base scope search on their own entry",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPSelfSearchGroups,
+			EnvVars: []string{"LDAP_SELF_SEARCH_GROUPS"},
+			Usage:   "also allow bound gitea users to do base scope search on their groups, implies --" + flagLDAPSelfSearch,
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPPasswordModify,
+			EnvVars: []string{"LDAP_PASSWORD_MODIFY"},
+			Usage:   "allow gitea users to change their password using ldap password modify extended operation",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPTokenOnly,
+			EnvVars: []string{"LDAP_TOKEN_ONLY"},
+			Usage:   "only accept gitea access token as bind password",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPOTP,
+			EnvVars: []string{"LDAP_OTP"},
+			Usage:   "accept gitea two-factor passcode appended to the bind password or as 'username;otp'",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPRequireOTP,
+			EnvVars: []string{"LDAP_REQUIRE_OTP"},
+			Usage:   "refuse password-only bind of users enrolled in two-factor authentication, implies --" + flagLDAPOTP,
+		},
 		&cli.IntFlag{
 			Name:    flagLDAPCacheSize,
 			EnvVars: []string{"LDAP_CACHE_SIZE"},
@@ -39,22 +140,213 @@ func ldapFlag() []cli.Flag {
 			EnvVars: []string{"LDAP_CACHE_EXPIRE_SECOND"},
 			Value:   60,
 		},
+		&cli.IntFlag{
+			Name:    flagLDAPCacheStaleSecond,
+			EnvVars: []string{"LDAP_CACHE_STALE_SECOND"},
+			Usage:   "keep serving expired cache for this many seconds while it is refreshed in the background",
+		},
+		&cli.IntFlag{
+			Name:    flagLDAPCacheRefresh,
+			EnvVars: []string{"LDAP_CACHE_REFRESH_SECOND"},
+			Usage:   "refresh the cache in the background at this interval, should be less than --" + flagLDAPCacheExpireSecond,
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPCacheRedisAddr,
+			EnvVars: []string{"LDAP_CACHE_REDIS_ADDR"},
+			Usage:   "address of redis protocol compatible server shared by replicas as cache, instead of in-process cache",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPCacheRedisPass,
+			EnvVars: []string{"LDAP_CACHE_REDIS_PASSWORD"},
+		},
+		&cli.IntFlag{
+			Name:    flagLDAPCacheRedisDB,
+			EnvVars: []string{"LDAP_CACHE_REDIS_DB"},
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPCacheRedisPrefix,
+			EnvVars: []string{"LDAP_CACHE_REDIS_PREFIX"},
+			Usage:   "prefix of cache keys",
+			Value:   "giteaty:",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPWebhookAddr,
+			EnvVars: []string{"LDAP_WEBHOOK_LISTEN_ADDR"},
+			Usage:   "listen address of http endpoint receiving webhooks that evict changed entries from the cache: gitea repository events, and user, organization, team, or membership events sent by other emitters. Only used when webhook secret is configured",
+			Value:   ":8389",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPWebhookSecret,
+			EnvVars: []string{"LDAP_WEBHOOK_SECRET"},
+			Usage:   "secret of gitea webhooks",
+		},
 		&cli.StringFlag{
 			Name:    flagLDAPListenAddr,
 			EnvVars: []string{"LDAP_LISTEN_ADDR"},
 			Value:   ":389",
 		},
+		&cli.StringFlag{
+			Name:    flagLDAPTLSListenAddr,
+			EnvVars: []string{"LDAP_TLS_LISTEN_ADDR"},
+			Usage:   "ldaps listen address, only used when tls certificate is configured",
+			Value:   ":636",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPTLSCert,
+			EnvVars: []string{"LDAP_TLS_CERT"},
+			Usage:   "path to PEM encoded certificate used for ldaps and starttls",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPTLSKey,
+			EnvVars: []string{"LDAP_TLS_KEY"},
+			Usage:   "path to PEM encoded private key of the certificate",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPRequireTLS,
+			EnvVars: []string{"LDAP_REQUIRE_TLS"},
+			Usage:   "refuse simple bind on connections not protected by tls",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPPosix,
+			EnvVars: []string{"LDAP_POSIX"},
+			Usage:   "add posixAccount and posixGroup attributes",
+		},
+		&cli.Int64Flag{
+			Name:    flagLDAPPosixIDOffset,
+			EnvVars: []string{"LDAP_POSIX_ID_OFFSET"},
+			Usage:   "added to gitea user and organization id to form uidNumber and gidNumber",
+			Value:   10000,
+		},
+		&cli.Int64Flag{
+			Name:    flagLDAPPosixTeamIDOffset,
+			EnvVars: []string{"LDAP_POSIX_TEAM_ID_OFFSET"},
+			Usage:   "added to gitea team id to form gidNumber, must be greater than the largest user gidNumber",
+			Value:   1000000000,
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPPosixHomeDir,
+			EnvVars: []string{"LDAP_POSIX_HOME_DIRECTORY"},
+			Usage:   "go template of homeDirectory, executed against gitea user",
+			Value:   "/home/{{.Name}}",
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPPosixLoginShell,
+			EnvVars: []string{"LDAP_POSIX_LOGIN_SHELL"},
+			Usage:   "go template of loginShell, executed against gitea user",
+			Value:   "/bin/bash",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPSSHPublicKeys,
+			EnvVars: []string{"LDAP_SSH_PUBLIC_KEYS"},
+			Usage:   "add users' ssh public keys as sshPublicKey attribute",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPExcludeDeployKeys,
+			EnvVars: []string{"LDAP_EXCLUDE_DEPLOY_KEYS"},
+			Usage:   "exclude deploy keys from sshPublicKey attribute",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPRepoGroups,
+			EnvVars: []string{"LDAP_REPO_GROUPS"},
+			Usage:   "add one group per repository under ou=repos whose members are users with read access",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPRepoAccessGroups,
+			EnvVars: []string{"LDAP_REPO_GROUPS_PER_ACCESS_LEVEL"},
+			Usage:   "add one group per repository and read, write, or admin access level, implies --" + flagLDAPRepoGroups,
+		},
+		&cli.StringFlag{
+			Name:    flagLDAPAdminGroup,
+			EnvVars: []string{"LDAP_ADMIN_GROUP"},
+			Usage:   "name of the group of gitea site administrators under ou=groups, e.g. gitea-admins. Must not be an organization name",
+		},
+		&cli.BoolFlag{
+			Name:    flagLDAPUserFlags,
+			EnvVars: []string{"LDAP_USER_FLAGS"},
+			Usage:   "add isAdmin, isRestricted, and prohibitLogin attributes to users",
+		},
 	}
 }
 
 func newLDAPHandler(c *cli.Context, m gitea.Models) (ldaphandler.Interface, error) {
-	return ldaphandler.New(
+	extensions := []string{}
+	if c.String(flagLDAPTLSCert) != "" {
+		extensions = append(extensions, ldapserver.OIDStartTLS)
+	}
+	if c.Bool(flagLDAPPasswordModify) {
+		extensions = append(extensions, ldapserver.OIDPasswordModify)
+	}
+	opts := ldaphandler.Options()
+	opts = append(opts,
 		ldaphandler.WithBaseDN(c.String(flagLDAPBaseDn)),
+		ldaphandler.WithProfile(c.String(flagLDAPProfile)),
+		ldaphandler.WithGroupUniqueAttribute(c.String(flagLDAPGroupAttribute)),
 		ldaphandler.WithSearchers(c.StringSlice(flagLDAPSearchers)),
-		ldaphandler.WithCache(c.Int(flagLDAPCacheSize), c.Int(flagLDAPCacheExpireSecond)),
+		ldaphandler.WithTokenOnly(c.Bool(flagLDAPTokenOnly)),
+		ldaphandler.WithCacheStale(c.Int(flagLDAPCacheStaleSecond)),
+		ldaphandler.WithSupportedExtensions(extensions),
 		ldaphandler.WithModels(m),
 		ldaphandler.WithLogger(log.GetPGlobal()),
 	)
+	if c.String(flagLDAPCacheRedisAddr) != "" {
+		rc, err := rediscache.New(c.String(flagLDAPCacheRedisAddr),
+			rediscache.WithPassword(c.String(flagLDAPCacheRedisPass)),
+			rediscache.WithDB(c.Int(flagLDAPCacheRedisDB)),
+			rediscache.WithPrefix(c.String(flagLDAPCacheRedisPrefix)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ldaphandler.WithCacheBackend(rc, c.Int(flagLDAPCacheExpireSecond)))
+	} else {
+		opts = append(opts, ldaphandler.WithCache(c.Int(flagLDAPCacheSize), c.Int(flagLDAPCacheExpireSecond)))
+	}
+	if c.Bool(flagLDAPPosix) {
+		opts = append(opts, ldaphandler.WithPosix(
+			c.Int64(flagLDAPPosixIDOffset), c.Int64(flagLDAPPosixTeamIDOffset),
+			c.String(flagLDAPPosixHomeDir), c.String(flagLDAPPosixLoginShell),
+		))
+	}
+	if c.String(flagLDAPUserAttribute) != "" {
+		opts = append(opts, ldaphandler.WithUserUniqueAttribute(c.String(flagLDAPUserAttribute)))
+	}
+	if c.String(flagLDAPAttributeMapping) != "" {
+		opts = append(opts, ldaphandler.WithAttributeMapping(c.String(flagLDAPAttributeMapping)))
+	}
+	if c.String(flagLDAPServiceAccounts) != "" {
+		opts = append(opts, ldaphandler.WithServiceAccounts(c.String(flagLDAPServiceAccounts)))
+	}
+	if c.String(flagLDAPACL) != "" {
+		opts = append(opts, ldaphandler.WithACL(c.String(flagLDAPACL)))
+	}
+	if c.Bool(flagLDAPSelfSearch) || c.Bool(flagLDAPSelfSearchGroups) {
+		opts = append(opts, ldaphandler.WithSelfSearch(c.Bool(flagLDAPSelfSearchGroups)))
+	}
+	if c.Bool(flagLDAPPasswordModify) {
+		opts = append(opts, ldaphandler.WithPasswordModify())
+	}
+	if c.Bool(flagLDAPOTP) || c.Bool(flagLDAPRequireOTP) {
+		opts = append(opts, ldaphandler.WithOTP(c.Bool(flagLDAPRequireOTP)))
+	}
+	if c.Int(flagLDAPCacheRefresh) > 0 {
+		opts = append(opts, ldaphandler.WithCacheRefresh(c.Context, c.Int(flagLDAPCacheRefresh)))
+	}
+	if c.String(flagLDAPWebhookSecret) != "" {
+		opts = append(opts, ldaphandler.WithWebhookSecret(c.String(flagLDAPWebhookSecret)))
+	}
+	if c.Bool(flagLDAPSSHPublicKeys) {
+		opts = append(opts, ldaphandler.WithSSHPublicKeys(c.Bool(flagLDAPExcludeDeployKeys)))
+	}
+	if c.String(flagLDAPAdminGroup) != "" {
+		opts = append(opts, ldaphandler.WithAdminGroup(c.String(flagLDAPAdminGroup)))
+	}
+	if c.Bool(flagLDAPUserFlags) {
+		opts = append(opts, ldaphandler.WithUserFlags())
+	}
+	if c.Bool(flagLDAPRepoGroups) || c.Bool(flagLDAPRepoAccessGroups) {
+		opts = append(opts, ldaphandler.WithRepoGroups(c.Bool(flagLDAPRepoAccessGroups)))
+	}
+	return ldaphandler.New(opts...)
 }
 
 func startLDAP(c *cli.Context, m gitea.Models) (err error) {
@@ -63,19 +355,55 @@ func startLDAP(c *cli.Context, m gitea.Models) (err error) {
 		return
 	}
 
-	done := false
-	quit := make(chan bool)
-	go func() { <-c.Context.Done(); done = true; close(quit) }()
+	opts := ldapserver.Options()
+	opts = append(opts, ldapserver.WithLogger(log.GetPGlobal()))
+	opts = append(opts, ldapserver.WithRequireTLS(c.Bool(flagLDAPRequireTLS)))
+	useTLS := c.String(flagLDAPTLSCert) != ""
+	if useTLS {
+		opts = append(opts, ldapserver.WithTLS(c.String(flagLDAPTLSCert), c.String(flagLDAPTLSKey)))
+	}
+	s, err := ldapserver.New(h, opts...)
+	if err != nil {
+		return
+	}
+
+	servers := []func(ctx context.Context) error{
+		func(ctx context.Context) error { return s.ListenAndServe(ctx, c.String(flagLDAPListenAddr)) },
+	}
+	if useTLS {
+		servers = append(servers, func(ctx context.Context) error {
+			return s.ListenAndServeTLS(ctx, c.String(flagLDAPTLSListenAddr))
+		})
+	}
+	if c.String(flagLDAPWebhookSecret) != "" {
+		servers = append(servers, func(ctx context.Context) error {
+			return serveWebhook(ctx, c.String(flagLDAPWebhookAddr), h)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	errs := make(chan error, len(servers))
+	for _, serve := range servers {
+		go func(serve func(ctx context.Context) error) { errs <- serve(ctx) }(serve)
+	}
+	err = <-errs
+	cancel()
+	for i := 1; i < len(servers); i++ {
+		if err2 := <-errs; err == nil {
+			err = err2
+		}
+	}
+	return
+}
 
-	s := ldaps.NewServer()
-	s.EnforceLDAP = true
-	s.BindFunc("", h)
-	s.SearchFunc("", h)
-	s.QuitChannel(quit)
+// serveWebhook serve gitea webhooks until ctx is done
+func serveWebhook(ctx context.Context, addr string, h http.Handler) (err error) {
+	srv := &http.Server{Addr: addr, Handler: h}
+	go func() { <-ctx.Done(); srv.Close() }()
 
-	err = s.ListenAndServe(c.String(flagLDAPListenAddr))
-	if err != nil && done {
-		err = nil
+	if err = srv.ListenAndServe(); err == http.ErrServerClosed {
+		return nil
 	}
 	return
 }
@@ -1,6 +1,10 @@
 package command
 
 import (
+	"fmt"
+
+	"github.com/rucciva/giteaty/pkg/gitea"
+	"github.com/rucciva/giteaty/pkg/gitea/api"
 	"github.com/rucciva/giteaty/pkg/gitea/globals"
 	"github.com/urfave/cli/v2"
 )
@@ -23,8 +27,13 @@ const (
 	flagDBMaxOpenConns        = "db-max-open-conns"
 	flagDBConnMaxLifetime     = "db-conn-max-lifetime"
 	flagDBIterateBufferSize   = "db-iterate-buffer-size"
+	flagDBReadOnly            = "db-read-only"
 
 	flagGiteaConf = "gitea-conf"
+
+	flagGiteaAPIURL      = "gitea-api-url"
+	flagGiteaAPIToken    = "gitea-api-token"
+	flagGiteaAPIInsecure = "gitea-api-insecure"
 )
 
 func modelsFlag() []cli.Flag {
@@ -98,14 +107,66 @@ func modelsFlag() []cli.Flag {
 			Name:    flagDBIterateBufferSize,
 			EnvVars: []string{"DB_ITERATE_BUFFER_SIZE"},
 		},
+		&cli.BoolFlag{
+			Name:    flagDBReadOnly,
+			EnvVars: []string{"DB_READ_ONLY"},
+			Usage:   "read only the tables needed by giteaty, which works with the schema of gitea 1.9 and newer, instead of using gitea's models",
+		},
 
 		&cli.StringFlag{
 			Name:    flagGiteaConf,
 			EnvVars: []string{"GITEA_CONF"},
 		},
+
+		&cli.StringFlag{
+			Name:    flagGiteaAPIURL,
+			EnvVars: []string{"GITEA_API_URL"},
+			Usage: "url of gitea, when set gitea's http api is used instead of its database, while gitea's models are still linked. Repository groups, one-time passwords, and password " +
+				"modify are not supported, and users are active and public unless gitea reports otherwise, which gitea 1.12 does not",
+		},
+		&cli.StringFlag{
+			Name:    flagGiteaAPIToken,
+			EnvVars: []string{"GITEA_API_TOKEN"},
+			Usage:   "access token of a gitea admin used with --" + flagGiteaAPIURL,
+		},
+		&cli.BoolFlag{
+			Name:    flagGiteaAPIInsecure,
+			EnvVars: []string{"GITEA_API_INSECURE"},
+			Usage:   "skip verification of gitea's tls certificate",
+		},
 	}
 }
 
+func initModels(c *cli.Context) (mdl gitea.Models, err error) {
+	if !c.IsSet(flagGiteaAPIURL) {
+		if c.Bool(flagDBReadOnly) {
+			for _, f := range []string{flagLDAPOTP, flagLDAPPasswordModify, flagLDAPRepoGroups} {
+				if c.Bool(f) {
+					return nil, fmt.Errorf("--%s is not supported with --%s", f, flagDBReadOnly)
+				}
+			}
+		}
+		if err = initDB(c); err != nil {
+			return nil, fmt.Errorf("init database failed: %v", err)
+		}
+		return globals.Models(), nil
+	}
+
+	for _, f := range []string{flagLDAPOTP, flagLDAPPasswordModify, flagLDAPRepoGroups} {
+		if c.Bool(f) {
+			return nil, fmt.Errorf("--%s is not supported with --%s", f, flagGiteaAPIURL)
+		}
+	}
+	opts := api.Options()
+	if c.Bool(flagGiteaAPIInsecure) {
+		opts = append(opts, api.WithInsecure())
+	}
+	if mdl, err = api.New(c.String(flagGiteaAPIURL), c.String(flagGiteaAPIToken), opts...); err != nil {
+		return nil, fmt.Errorf("init gitea api failed: %v", err)
+	}
+	return
+}
+
 func initDB(c *cli.Context) (err error) {
 	opts := globals.ModelsOptions()
 	if c.IsSet(flagGiteaConf) {
@@ -162,5 +223,8 @@ func initDB(c *cli.Context) (err error) {
 		opts = append(opts, globals.ModelsWithDBIterateBufferSize(c.Int(flagDBIterateBufferSize)))
 	}
 
+	if c.Bool(flagDBReadOnly) {
+		opts = append(opts, globals.ModelsWithReadOnly())
+	}
 	return globals.InitModels(opts...)
 }
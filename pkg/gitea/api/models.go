@@ -0,0 +1,555 @@
+// Package api implements gitea.Models on top of Gitea's HTTP API using an admin token, so that giteaty does
+// not need access to Gitea's database. It only removes the need for database credentials: results are converted
+// to the types of Gitea's models package, so that package is still linked and the binary is not smaller
+package api
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	"code.gitea.io/gitea/modules/timeutil"
+	sdk "code.gitea.io/sdk/gitea"
+	"github.com/rucciva/giteaty/pkg/gitea"
+)
+
+var (
+	_ gitea.Models = &Models{}
+
+	// ErrNotSupported is returned by operations that have no equivalent in Gitea's API
+	ErrNotSupported = errors.New("not supported by gitea api")
+)
+
+// pageSize is the default maximum number of items returned by Gitea's API
+const pageSize = 50
+
+type option = func(m *Models) error
+
+func Options() []option {
+	return make([]func(m *Models) error, 0, 2)
+}
+
+// WithInsecure skip verification of Gitea's TLS certificate
+func WithInsecure() option {
+	return func(m *Models) (err error) {
+		m.hc.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		return
+	}
+}
+
+// WithTimeout set the timeout of every request to Gitea
+func WithTimeout(timeout time.Duration) option {
+	return func(m *Models) (err error) {
+		m.hc.Timeout = timeout
+		return
+	}
+}
+
+// Models is safe for concurrent use. Names of users and organizations are remembered by ID,
+// since most of Gitea's API address them by name
+type Models struct {
+	url   string
+	token string
+	hc    *http.Client
+
+	mu    sync.Mutex
+	names map[int64]string
+}
+
+// New create Models using the token of a Gitea admin
+func New(url, token string, opts ...option) (m *Models, err error) {
+	if url == "" {
+		return nil, fmt.Errorf("gitea url is required")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("gitea admin token is required")
+	}
+	m = &Models{
+		url:   strings.TrimSuffix(url, "/"),
+		token: token,
+		hc:    &http.Client{Timeout: 30 * time.Second},
+		names: map[int64]string{},
+	}
+	for _, opt := range opts {
+		if err = opt(m); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// client return admin client acting as the sudo user when it is not empty
+func (m *Models) client(sudo string) *sdk.Client {
+	c := sdk.NewClient(m.url, m.token)
+	c.SetHTTPClient(m.hc)
+	if sudo != "" {
+		c.SetSudo(sudo)
+	}
+	return c
+}
+
+// getJSON decode the response of the api path. Unsuccessful responses are returned as error starting with
+// the status code, like the errors of the sdk, together with the status code
+func (m *Models) getJSON(authorization, path string, query url.Values, v interface{}) (code int, err error) {
+	u := m.url + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", authorization)
+	res, err := m.hc.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, errors.New(res.Status)
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(v)
+}
+
+// whoami return the user authenticated by the authorization header, and the response status code
+func (m *Models) whoami(authorization string) (u *apiUser, code int, err error) {
+	u = &apiUser{}
+	code, err = m.getJSON(authorization, "/user", nil, u)
+	if code != 0 && code != http.StatusOK {
+		return nil, code, nil
+	}
+	return
+}
+
+// listAll call list with increasing page until it returns no items
+func listAll(list func(opts sdk.ListOptions) (n int, err error)) (err error) {
+	for page := 1; ; page++ {
+		n, err := list(sdk.ListOptions{Page: page, PageSize: pageSize})
+		if err != nil || n == 0 {
+			return err
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "404")
+}
+
+func (m *Models) remember(id int64, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.names[id] = name
+}
+
+// apiUser is the user of Gitea's API, including fields added after Gitea 1.12. When missing, as reported by
+// Gitea 1.12, users are active, allowed to sign in, unrestricted, and public
+type apiUser struct {
+	sdk.User
+	Active        *bool  `json:"active"`
+	ProhibitLogin bool   `json:"prohibit_login"`
+	Restricted    bool   `json:"restricted"`
+	Visibility    string `json:"visibility"`
+}
+
+func (m *Models) toUser(u *apiUser) *models.User {
+	m.remember(u.ID, u.UserName)
+	return &models.User{
+		ID:            u.ID,
+		Name:          u.UserName,
+		LowerName:     strings.ToLower(u.UserName),
+		FullName:      u.FullName,
+		Email:         u.Email,
+		IsAdmin:       u.IsAdmin,
+		IsActive:      u.Active == nil || *u.Active,
+		ProhibitLogin: u.ProhibitLogin,
+		IsRestricted:  u.Restricted,
+		Type:          models.UserTypeIndividual,
+		Visibility:    structs.VisibilityModes[u.Visibility],
+		CreatedUnix:   timeutil.TimeStamp(u.Created.Unix()),
+	}
+}
+
+func (m *Models) toUsers(us []*apiUser) []*models.User {
+	users := make([]*models.User, 0, len(us))
+	for _, u := range us {
+		users = append(users, m.toUser(u))
+	}
+	return users
+}
+
+func (m *Models) toOrg(o *sdk.Organization) *models.User {
+	m.remember(o.ID, o.UserName)
+	return &models.User{
+		ID:          o.ID,
+		Name:        o.UserName,
+		LowerName:   strings.ToLower(o.UserName),
+		FullName:    o.FullName,
+		Description: o.Description,
+		IsActive:    true,
+		Type:        models.UserTypeOrganization,
+		Visibility:  structs.VisibilityModes[o.Visibility],
+	}
+}
+
+func toTeam(t *sdk.Team, orgID int64) *models.Team {
+	if t.Organization != nil {
+		orgID = t.Organization.ID
+	}
+	return &models.Team{
+		ID:          t.ID,
+		OrgID:       orgID,
+		Name:        t.Name,
+		LowerName:   strings.ToLower(t.Name),
+		Description: t.Description,
+		Authorize:   parseAccessMode(t.Permission),
+	}
+}
+
+func parseAccessMode(permission string) models.AccessMode {
+	if permission == "owner" {
+		return models.AccessModeOwner
+	}
+	return models.ParseAccessMode(permission)
+}
+
+// name return the name of the user or organization. Unknown users are searched by ID, and unknown organizations,
+// which can not be searched by ID, by listing every organization
+func (m *Models) name(id int64) (name string, err error) {
+	lookup := func() (name string, ok bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		name, ok = m.names[id]
+		return
+	}
+	if name, ok := lookup(); ok {
+		return name, nil
+	}
+	users, err := m.searchUsers(url.Values{"uid": {strconv.FormatInt(id, 10)}})
+	if err != nil {
+		return
+	}
+	if len(users) > 0 {
+		return users[0].Name, nil
+	}
+	if _, err = m.listOrgs(); err != nil {
+		return
+	}
+	if name, ok := lookup(); ok {
+		return name, nil
+	}
+	return "", models.ErrUserNotExist{UID: id}
+}
+
+// listUsersOf list every user returned by the api path
+func (m *Models) listUsersOf(path string) (users []*models.User, err error) {
+	err = listAll(func(opts sdk.ListOptions) (int, error) {
+		var us []*apiUser
+		query := url.Values{"page": {strconv.Itoa(opts.Page)}, "limit": {strconv.Itoa(opts.PageSize)}}
+		_, err := m.getJSON("token "+m.token, path, query, &us)
+		users = append(users, m.toUsers(us)...)
+		return len(us), err
+	})
+	return
+}
+
+// searchUsers list every user matching the query of /users/search, e.g. q or uid
+func (m *Models) searchUsers(query url.Values) (users []*models.User, err error) {
+	err = listAll(func(opts sdk.ListOptions) (int, error) {
+		var res struct {
+			Data []*apiUser `json:"data"`
+		}
+		q := url.Values{"page": {strconv.Itoa(opts.Page)}, "limit": {strconv.Itoa(opts.PageSize)}}
+		for k, v := range query {
+			q[k] = v
+		}
+		_, err := m.getJSON("token "+m.token, "/users/search", q, &res)
+		users = append(users, m.toUsers(res.Data)...)
+		return len(res.Data), err
+	})
+	return
+}
+
+func (m *Models) listUsers() (users []*models.User, err error) {
+	return m.listUsersOf("/admin/users")
+}
+
+func (m *Models) listOrgs() (orgs []*models.User, err error) {
+	c := m.client("")
+	err = listAll(func(opts sdk.ListOptions) (int, error) {
+		res, err := c.AdminListOrgs(sdk.AdminListOrgsOptions{ListOptions: opts})
+		for _, o := range res {
+			orgs = append(orgs, m.toOrg(o))
+		}
+		return len(res), err
+	})
+	return
+}
+
+// UserSignIn verify the password using basic authentication. Like Gitea's API, users enrolled in two-factor
+// authentication can not sign in this way. Gitea also accepts an access token as the password, or as the username
+// when the password is empty, so the password must not be empty and the authenticated user must be the given one
+func (m *Models) UserSignIn(username, password string) (*models.User, error) {
+	if password == "" {
+		return nil, models.ErrUserNotExist{Name: username}
+	}
+	u, code, err := m.whoami("Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	switch {
+	case err != nil:
+		return nil, err
+	case code == http.StatusUnauthorized:
+		return nil, models.ErrUserNotExist{Name: username}
+	case code == http.StatusForbidden:
+		return nil, models.ErrUserProhibitLogin{Name: username}
+	case u == nil:
+		return nil, fmt.Errorf("unexpected status code %d", code)
+	case !strings.EqualFold(u.UserName, username):
+		return nil, models.ErrUserNotExist{Name: username}
+	}
+	return m.toUser(u), nil
+}
+
+// GetAccessTokenBySHA return an access token carrying only the ID of its owner
+func (m *Models) GetAccessTokenBySHA(token string) (*models.AccessToken, error) {
+	if token == "" {
+		return nil, models.ErrAccessTokenEmpty{}
+	}
+	u, code, err := m.whoami("token " + token)
+	switch {
+	case err != nil:
+		return nil, err
+	case code == http.StatusUnauthorized:
+		return nil, models.ErrAccessTokenNotExist{Token: token}
+	case u == nil:
+		return nil, fmt.Errorf("unexpected status code %d", code)
+	}
+	m.remember(u.ID, u.UserName)
+	return &models.AccessToken{UID: u.ID}, nil
+}
+
+// GetTwoFactorByUID always report that the user is not enrolled, since users enrolled in two-factor
+// authentication can not sign in through the api
+func (m *Models) GetTwoFactorByUID(uid int64) (*models.TwoFactor, error) {
+	return nil, models.ErrTwoFactorNotEnrolled{UID: uid}
+}
+
+func (m *Models) UpdateTwoFactor(t *models.TwoFactor) error {
+	return ErrNotSupported
+}
+
+func (m *Models) CheckPasswordPolicy(passwd string) error {
+	return ErrNotSupported
+}
+
+// UpdateUserPassword is not supported, since Gitea's admin api overwrites the full name, website, location, and
+// login name of the user with the values sent along the password, while Gitea 1.12's api does not report them
+func (m *Models) UpdateUserPassword(u *models.User, passwd string) error {
+	return ErrNotSupported
+}
+
+// SearchUsers list users or organizations. Besides Type, Keyword, and SearchByEmail, the options are ignored
+func (m *Models) SearchUsers(opts *models.SearchUserOptions) (users []*models.User, count int64, err error) {
+	switch {
+	case opts.Type == models.UserTypeOrganization:
+		users, err = m.listOrgs()
+	case opts.Keyword != "" && !opts.SearchByEmail:
+		users, err = m.searchUsers(url.Values{"q": {opts.Keyword}})
+	default:
+		users, err = m.listUsers()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kw := strings.ToLower(opts.Keyword); kw != "" && (opts.Type == models.UserTypeOrganization || opts.SearchByEmail) {
+		matched := users[:0]
+		for _, u := range users {
+			if strings.Contains(u.LowerName, kw) || strings.Contains(strings.ToLower(u.FullName), kw) ||
+				(opts.SearchByEmail && strings.Contains(strings.ToLower(u.Email), kw)) {
+				matched = append(matched, u)
+			}
+		}
+		users = matched
+	}
+	return users, int64(len(users)), nil
+}
+
+// GetUserByName get the user or organization by name. Gitea returns both as users, so the user is searched
+// by its id, which only finds individual users, to tell them apart
+func (m *Models) GetUserByName(name string) (*models.User, error) {
+	u := &apiUser{}
+	_, err := m.getJSON("token "+m.token, "/users/"+url.PathEscape(name), nil, u)
+	if isNotFound(err) {
+		return nil, models.ErrUserNotExist{Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	users, err := m.searchUsers(url.Values{"uid": {strconv.FormatInt(u.ID, 10)}})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		return users[0], nil
+	}
+
+	o := &sdk.Organization{}
+	_, err = m.getJSON("token "+m.token, "/orgs/"+url.PathEscape(u.UserName), nil, o)
+	if isNotFound(err) {
+		return nil, models.ErrUserNotExist{Name: name}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.toOrg(o), nil
+}
+
+// GetUsersByIDs search the users by ID one at a time, unless there are more of them than a page of users
+func (m *Models) GetUsersByIDs(ids []int64) (models.UserList, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if len(ids) <= pageSize {
+		users := make(models.UserList, 0, len(ids))
+		for _, id := range ids {
+			found, err := m.searchUsers(url.Values{"uid": {strconv.FormatInt(id, 10)}})
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, found...)
+		}
+		return users, nil
+	}
+
+	all, err := m.listUsers()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	users := make(models.UserList, 0, len(ids))
+	for _, u := range all {
+		if wanted[u.ID] {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// GetUserTeams list the teams of the user by acting as the user
+func (m *Models) GetUserTeams(userID int64, listOptions models.ListOptions) (teams []*models.Team, err error) {
+	name, err := m.name(userID)
+	if err != nil {
+		return
+	}
+	c := m.client(name)
+	err = listAll(func(opts sdk.ListOptions) (int, error) {
+		ts, err := c.ListMyTeams(&sdk.ListTeamsOptions{ListOptions: opts})
+		for _, t := range ts {
+			teams = append(teams, toTeam(t, 0))
+		}
+		return len(ts), err
+	})
+	return
+}
+
+func (m *Models) GetOrgUsersByOrgID(opts *models.FindOrgMembersOpts) (ous []*models.OrgUser, err error) {
+	org, err := m.name(opts.OrgID)
+	if err != nil {
+		return
+	}
+	c := m.client("")
+	err = listAll(func(lo sdk.ListOptions) (int, error) {
+		us, err := c.ListOrgMembership(org, sdk.ListOrgMembershipOption{ListOptions: lo})
+		for _, u := range us {
+			m.remember(u.ID, u.UserName)
+			ous = append(ous, &models.OrgUser{UID: u.ID, OrgID: opts.OrgID})
+		}
+		return len(us), err
+	})
+	return
+}
+
+// SearchTeam list teams of the organization matching the keyword. The paging options are ignored
+func (m *Models) SearchTeam(opts *models.SearchTeamOptions) (teams []*models.Team, count int64, err error) {
+	org, err := m.name(opts.OrgID)
+	if err != nil {
+		return
+	}
+	c, kw := m.client(""), strings.ToLower(opts.Keyword)
+	err = listAll(func(lo sdk.ListOptions) (int, error) {
+		ts, err := c.ListOrgTeams(org, sdk.ListTeamsOptions{ListOptions: lo})
+		for _, t := range ts {
+			if strings.Contains(strings.ToLower(t.Name), kw) {
+				teams = append(teams, toTeam(t, opts.OrgID))
+			}
+		}
+		return len(ts), err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return teams, int64(len(teams)), nil
+}
+
+func (m *Models) GetTeam(orgID int64, name string) (*models.Team, error) {
+	teams, _, err := m.SearchTeam(&models.SearchTeamOptions{OrgID: orgID, Keyword: name})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range teams {
+		if strings.EqualFold(t.Name, name) {
+			return t, nil
+		}
+	}
+	return nil, models.ErrTeamNotExist{OrgID: orgID, Name: name}
+}
+
+func (m *Models) GetTeamMembers(teamID int64) (users []*models.User, err error) {
+	return m.listUsersOf("/teams/" + strconv.FormatInt(teamID, 10) + "/members")
+}
+
+// ListPublicKeys list the user's public keys. Deploy keys are not included. The paging options are ignored
+func (m *Models) ListPublicKeys(uid int64, listOptions models.ListOptions) (keys []*models.PublicKey, err error) {
+	name, err := m.name(uid)
+	if err != nil {
+		return
+	}
+	c := m.client("")
+	err = listAll(func(opts sdk.ListOptions) (int, error) {
+		pks, err := c.ListPublicKeys(name, sdk.ListPublicKeysOptions{ListOptions: opts})
+		for _, pk := range pks {
+			keys = append(keys, &models.PublicKey{
+				ID:          pk.ID,
+				OwnerID:     uid,
+				Name:        pk.Title,
+				Fingerprint: pk.Fingerprint,
+				Content:     pk.Key,
+				Mode:        models.AccessModeWrite,
+				Type:        models.KeyTypeUser,
+				CreatedUnix: timeutil.TimeStamp(pk.Created.Unix()),
+			})
+		}
+		return len(pks), err
+	})
+	return
+}
+
+func (m *Models) SearchRepository(opts *models.SearchRepoOptions) (models.RepositoryList, int64, error) {
+	return nil, 0, ErrNotSupported
+}
+
+func (m *Models) GetRepoUsersByAccessMode(repo *models.Repository, mode models.AccessMode) ([]*models.User, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	sdk "code.gitea.io/sdk/gitea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tServer is a stand-in of Gitea's API serving a single page of every listing
+type tServer struct {
+	*httptest.Server
+	listedUsers int64
+	editedUsers int64
+}
+
+func newTServer(t *testing.T) *tServer {
+	alice := &sdk.User{ID: 1, UserName: "alice", Email: "alice@domain.com", IsAdmin: true}
+	bob := &sdk.User{ID: 2, UserName: "bob", FullName: "Bob", Email: "bob@domain.com"}
+	inactive := false
+	carol := &apiUser{User: sdk.User{ID: 6, UserName: "carol"}, Active: &inactive, ProhibitLogin: true, Visibility: "limited"}
+	s := &tServer{}
+	org := &sdk.Organization{ID: 3, UserName: "org", Visibility: "private"}
+	team := &sdk.Team{ID: 4, Name: "Owners", Permission: "owner"}
+
+	page := func(w http.ResponseWriter, r *http.Request, v interface{}) {
+		if r.URL.Query().Get("page") != "1" {
+			v = []interface{}{}
+		}
+		json.NewEncoder(w).Encode(v)
+	}
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token admin" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/user", func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		switch {
+		case ok && strings.EqualFold(u, "alice") && p == "secret", r.Header.Get("Authorization") == "token alice":
+			json.NewEncoder(w).Encode(alice)
+		case ok && (p == "alice" || (u == "alice" && p == "")):
+			// like gitea, an access token is accepted as the password, or as the username without password
+			json.NewEncoder(w).Encode(alice)
+		case ok && u == "bob" && p == "secret":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("/api/v1/admin/users", admin(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.listedUsers, 1)
+		page(w, r, []interface{}{alice, bob, carol})
+	}))
+	mux.HandleFunc("/api/v1/admin/users/", admin(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.editedUsers, 1)
+		json.NewEncoder(w).Encode(bob)
+	}))
+	mux.HandleFunc("/api/v1/admin/orgs", admin(func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []*sdk.Organization{org})
+	}))
+	mux.HandleFunc("/api/v1/users/search", admin(func(w http.ResponseWriter, r *http.Request) {
+		data := []*sdk.User{}
+		q := r.URL.Query()
+		if q.Get("page") == "1" && (q.Get("q") == "bob" || q.Get("uid") == "2") {
+			data = append(data, bob)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "data": data})
+	}))
+	mux.HandleFunc("/api/v1/users/bob", admin(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(bob)
+	}))
+	mux.HandleFunc("/api/v1/users/org", admin(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&sdk.User{ID: org.ID, UserName: org.UserName})
+	}))
+	mux.HandleFunc("/api/v1/orgs/org", admin(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(org)
+	}))
+	mux.HandleFunc("/api/v1/users/bob/keys", admin(func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []*sdk.PublicKey{{ID: 5, Key: "ssh-ed25519 AAAA", Title: "laptop"}})
+	}))
+	mux.HandleFunc("/api/v1/user/teams", admin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Sudo") != "bob" {
+			page(w, r, []*sdk.Team{})
+			return
+		}
+		page(w, r, []*sdk.Team{{ID: team.ID, Name: team.Name, Permission: team.Permission, Organization: org}})
+	}))
+	mux.HandleFunc("/api/v1/orgs/org/teams", admin(func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []*sdk.Team{team})
+	}))
+	mux.HandleFunc("/api/v1/orgs/org/members", admin(func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []*sdk.User{bob})
+	}))
+	mux.HandleFunc("/api/v1/teams/4/members", admin(func(w http.ResponseWriter, r *http.Request) {
+		page(w, r, []*sdk.User{bob})
+	}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("", "admin")
+	assert.Error(t, err, "should require url")
+	_, err = New("http://gitea", "")
+	assert.Error(t, err, "should require token")
+}
+
+func TestUserSignIn(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	u, err := m.UserSignIn("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.ID)
+	assert.Equal(t, "alice", u.Name)
+	assert.True(t, u.IsAdmin)
+	assert.True(t, u.IsActive)
+	u, err = m.UserSignIn("ALICE", "secret")
+	require.NoError(t, err, "should compare username case insensitively")
+	assert.Equal(t, int64(1), u.ID)
+
+	_, err = m.UserSignIn("bob", "alice")
+	assert.True(t, models.IsErrUserNotExist(err), "should not sign in using token of another user")
+	_, err = m.UserSignIn("alice", "")
+	assert.True(t, models.IsErrUserNotExist(err), "should reject empty password")
+
+	_, err = m.UserSignIn("alice", "invalid")
+	assert.True(t, models.IsErrUserNotExist(err), "should return user not exist on invalid password")
+	_, err = m.UserSignIn("bob", "secret")
+	assert.True(t, models.IsErrUserProhibitLogin(err), "should return prohibit login when forbidden")
+}
+
+func TestGetAccessTokenBySHA(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	token, err := m.GetAccessTokenBySHA("alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), token.UID)
+
+	_, err = m.GetAccessTokenBySHA("invalid")
+	assert.True(t, models.IsErrAccessTokenNotExist(err))
+	_, err = m.GetAccessTokenBySHA("")
+	assert.True(t, models.IsErrAccessTokenEmpty(err))
+}
+
+func TestUpdateUserPassword(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	bob := &models.User{ID: 2, Name: "bob", FullName: "Bob", Website: "https://bob.domain.com", LoginName: "bob@ldap"}
+	assert.Equal(t, ErrNotSupported, m.CheckPasswordPolicy("secret"))
+	assert.Equal(t, ErrNotSupported, m.UpdateUserPassword(bob, "secret"))
+	assert.Zero(t, atomic.LoadInt64(&s.editedUsers), "should not overwrite the other fields of the user")
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	users, count, err := m.SearchUsers(&models.SearchUserOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	require.Len(t, users, 3)
+	assert.Equal(t, "alice", users[0].Name)
+	assert.Equal(t, models.UserTypeIndividual, users[0].Type)
+	assert.True(t, users[0].IsActive, "should be active when gitea does not report it")
+	assert.Equal(t, structs.VisibleTypePublic, users[0].Visibility, "should be public when gitea does not report it")
+	assert.False(t, users[2].IsActive, "should use the state reported by gitea")
+	assert.True(t, users[2].ProhibitLogin)
+	assert.Equal(t, structs.VisibleTypeLimited, users[2].Visibility)
+
+	users, _, err = m.SearchUsers(&models.SearchUserOptions{Keyword: "bob"})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "bob@domain.com", users[0].Email)
+
+	users, _, err = m.SearchUsers(&models.SearchUserOptions{Keyword: "alice@domain", SearchByEmail: true})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "alice", users[0].Name)
+
+	orgs, _, err := m.SearchUsers(&models.SearchUserOptions{Type: models.UserTypeOrganization})
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, "org", orgs[0].Name)
+	assert.Equal(t, models.UserTypeOrganization, orgs[0].Type)
+	assert.Equal(t, structs.VisibleTypePrivate, orgs[0].Visibility)
+
+	listed := atomic.LoadInt64(&s.listedUsers)
+	users, err = m.GetUsersByIDs([]int64{2, 9})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "bob", users[0].Name)
+	assert.Equal(t, listed, atomic.LoadInt64(&s.listedUsers), "should search users by id instead of listing every user")
+
+	u, err := m.GetUserByName("bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), u.ID)
+	assert.Equal(t, models.UserTypeIndividual, u.Type)
+	u, err = m.GetUserByName("org")
+	require.NoError(t, err)
+	assert.Equal(t, models.UserTypeOrganization, u.Type, "should tell organizations apart from users")
+	assert.Equal(t, structs.VisibleTypePrivate, u.Visibility)
+	_, err = m.GetUserByName("carol")
+	assert.True(t, models.IsErrUserNotExist(err))
+}
+
+func TestTeams(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	teams, err := m.GetUserTeams(2, models.ListOptions{})
+	require.NoError(t, err, "should resolve the name of user by searching its id")
+	assert.Zero(t, atomic.LoadInt64(&s.listedUsers), "should not list every user")
+	require.Len(t, teams, 1)
+	assert.Equal(t, int64(3), teams[0].OrgID)
+	assert.Equal(t, models.AccessModeOwner, teams[0].Authorize)
+
+	teams, count, err := m.SearchTeam(&models.SearchTeamOptions{OrgID: 3})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int64(3), teams[0].OrgID)
+
+	team, err := m.GetTeam(3, "owners")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), team.ID)
+	_, err = m.GetTeam(3, "developers")
+	assert.True(t, models.IsErrTeamNotExist(err))
+
+	members, err := m.GetTeamMembers(4)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "bob", members[0].Name)
+
+	ous, err := m.GetOrgUsersByOrgID(&models.FindOrgMembersOpts{OrgID: 3})
+	require.NoError(t, err)
+	require.Len(t, ous, 1)
+	assert.Equal(t, int64(2), ous[0].UID)
+
+	_, err = m.GetUserTeams(9, models.ListOptions{})
+	assert.True(t, models.IsErrUserNotExist(err))
+}
+
+func TestListPublicKeys(t *testing.T) {
+	s := newTServer(t)
+	defer s.Close()
+	m, err := New(s.URL, "admin")
+	require.NoError(t, err)
+
+	_, err = m.GetUserByName("bob")
+	require.NoError(t, err)
+	keys, err := m.ListPublicKeys(2, models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "ssh-ed25519 AAAA", keys[0].Content)
+	assert.Equal(t, models.KeyType(models.KeyTypeUser), keys[0].Type)
+	assert.Equal(t, int64(2), keys[0].OwnerID)
+}
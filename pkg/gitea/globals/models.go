@@ -3,6 +3,7 @@ package globals
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"code.gitea.io/gitea/models/migrations"
 	"code.gitea.io/gitea/modules/setting"
 	"github.com/rucciva/giteaty/pkg/gitea"
+	"github.com/rucciva/giteaty/pkg/gitea/sqlstore"
 	"github.com/unknwon/com"
 	"gopkg.in/ini.v1"
 )
@@ -141,6 +143,14 @@ func ModelsWithGiteaConf(path string) modelsOption {
 		for k, v := range cfg.Section("database").KeysHash() {
 			g.cfg.Key(k).SetValue(v)
 		}
+		if g.security == nil {
+			return
+		}
+		for _, k := range passwordSettingKeys {
+			if sec := cfg.Section("security"); sec.HasKey(k) {
+				g.security.Key(k).SetValue(sec.Key(k).String())
+			}
+		}
 
 		return
 	}
@@ -155,10 +165,35 @@ func ModelsWithDBMigration() modelsOption {
 	}
 }
 
+// ModelsWithReadOnly read gitea's database using sqlstore instead of gitea's models package, which requires
+// the exact schema version it was built for
+func ModelsWithReadOnly() modelsOption {
+	return func(g *gModels) (err error) {
+		g.engineCreator = func() (err error) {
+			connStr, err := setting.DBConnStr()
+			if err != nil {
+				return
+			}
+			if setting.Database.UsePostgreSQL && setting.Database.Schema != "" {
+				connStr += "&search_path=" + url.QueryEscape(setting.Database.Schema)
+			}
+			g.store, err = sqlstore.New(setting.Database.Type, connStr,
+				sqlstore.WithMaxOpenConns(setting.Database.MaxOpenConns),
+				sqlstore.WithMaxIdleConns(setting.Database.MaxIdleConns),
+				sqlstore.WithConnMaxLifetime(setting.Database.ConnMaxLifetime),
+			)
+			return
+		}
+		return
+	}
+}
+
 type gModels struct {
-	cfg *ini.Section
+	cfg      *ini.Section
+	security *ini.Section
 
 	engineCreator func() error
+	store         gitea.Models
 }
 
 var gm *gModels
@@ -171,6 +206,7 @@ func InitModels(opts ...modelsOption) (err error) {
 	setting.Cfg = ini.Empty()
 	gm = &gModels{
 		cfg:           setting.Cfg.Section("database"),
+		security:      setting.Cfg.Section("security"),
 		engineCreator: models.SetEngine,
 	}
 	for _, opt := range opts {
@@ -179,6 +215,7 @@ func InitModels(opts ...modelsOption) (err error) {
 		}
 	}
 	setting.InitDBConfig()
+	loadPasswordSettings(gm.security)
 	if err := gm.engineCreator(); err != nil {
 		return fmt.Errorf("set engine failed: %v", err)
 	}
@@ -186,6 +223,9 @@ func InitModels(opts ...modelsOption) (err error) {
 }
 
 func Models() gitea.Models {
+	if gm != nil && gm.store != nil {
+		return gm.store
+	}
 	return gm
 }
 
@@ -200,3 +240,123 @@ func (gModels) GetUserTeams(userID int64, listOptions models.ListOptions) ([]*mo
 func (gModels) SearchUsers(opts *models.SearchUserOptions) (users []*models.User, count int64, err error) {
 	return models.SearchUsers(opts)
 }
+
+func (gModels) SearchTeam(opts *models.SearchTeamOptions) ([]*models.Team, int64, error) {
+	return models.SearchTeam(opts)
+}
+
+func (gModels) GetUsersByIDs(ids []int64) (models.UserList, error) {
+	return models.GetUsersByIDs(ids)
+}
+
+func (gModels) GetOrgUsersByOrgID(opts *models.FindOrgMembersOpts) ([]*models.OrgUser, error) {
+	return models.GetOrgUsersByOrgID(opts)
+}
+
+func (gModels) GetTeam(orgID int64, name string) (*models.Team, error) {
+	return models.GetTeam(orgID, name)
+}
+
+func (gModels) GetTeamMembers(teamID int64) ([]*models.User, error) {
+	return models.GetTeamMembers(teamID)
+}
+
+func (gModels) GetAccessTokenBySHA(token string) (*models.AccessToken, error) {
+	return models.GetAccessTokenBySHA(token)
+}
+
+func (gModels) GetTwoFactorByUID(uid int64) (*models.TwoFactor, error) {
+	return models.GetTwoFactorByUID(uid)
+}
+
+func (gModels) UpdateTwoFactor(t *models.TwoFactor) error {
+	return models.UpdateTwoFactor(t)
+}
+
+func (gModels) GetUserByName(name string) (*models.User, error) {
+	return models.GetUserByName(name)
+}
+
+func (gModels) ListPublicKeys(uid int64, listOptions models.ListOptions) ([]*models.PublicKey, error) {
+	return models.ListPublicKeys(uid, listOptions)
+}
+
+func (gModels) SearchRepository(opts *models.SearchRepoOptions) (models.RepositoryList, int64, error) {
+	return models.SearchRepository(opts)
+}
+
+// GetRepoUsersByAccessMode return users having at least the access mode to the repository
+func (gModels) GetRepoUsersByAccessMode(repo *models.Repository, mode models.AccessMode) (users []*models.User, err error) {
+	if mode <= models.AccessModeRead {
+		return getRepoReaders(repo)
+	}
+	writers, err := repo.GetWriters()
+	if err != nil || mode == models.AccessModeWrite {
+		return writers, err
+	}
+	for _, u := range writers {
+		level, err := models.AccessLevel(u, repo)
+		if err != nil {
+			return nil, err
+		}
+		if level >= mode {
+			users = append(users, u)
+		}
+	}
+	return
+}
+
+// getRepoReaders return the owner, collaborators, and members of teams having the code unit of the repository, the same
+// users gitea considers as explicit readers. Gitea's access table can not be used, since for public repositories
+// it only stores write and higher access
+func getRepoReaders(repo *models.Repository) (users []*models.User, err error) {
+	seen := map[int64]bool{}
+	add := func(us ...*models.User) {
+		for _, u := range us {
+			if !seen[u.ID] {
+				users = append(users, u)
+				seen[u.ID] = true
+			}
+		}
+	}
+
+	if err = repo.GetOwner(); err != nil {
+		return nil, err
+	}
+	if !repo.Owner.IsOrganization() {
+		add(repo.Owner)
+	}
+	collaborators, err := repo.GetCollaborators(models.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range collaborators {
+		add(c.User)
+	}
+	if !repo.Owner.IsOrganization() {
+		return
+	}
+	teams, err := repo.GetRepoTeams()
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		if !team.UnitEnabled(models.UnitTypeCode) {
+			continue
+		}
+		members, err := models.GetTeamMembers(team.ID)
+		if err != nil {
+			return nil, err
+		}
+		add(members...)
+	}
+	return
+}
+
+func (gModels) UpdateUserPassword(u *models.User, passwd string) (err error) {
+	if u.Salt, err = models.GetUserSalt(); err != nil {
+		return
+	}
+	u.HashPassword(passwd)
+	return models.UpdateUserCols(u, "salt", "passwd", "passwd_hash_algo")
+}
@@ -41,4 +41,33 @@ func TestModelsWithGiteaConf(t *testing.T) {
 	assert.Equal(t, "disable", g.cfg.Key("SSL_MODE").String())
 	assert.Equal(t, true, g.cfg.Key("LOG_SQL").MustBool())
 
+	security := ini.Empty().Section("security")
+	g = &gModels{cfg: cfg, security: security}
+	_ = ModelsWithGiteaConf(filepath.Join("testdata", "gitea.ini"))(g)
+	assert.Equal(t, "8", g.security.Key("MIN_PASSWORD_LENGTH").String())
+	assert.Equal(t, "lower,digit", g.security.Key("PASSWORD_COMPLEXITY").String())
+	assert.False(t, g.security.HasKey("PASSWORD_HASH_ALGO"))
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	data := []struct {
+		complexity string
+		valid      []string
+		invalid    []string
+	}{
+		{complexity: "off", valid: []string{"secret"}, invalid: []string{"short"}},
+		{complexity: "lower,digit", valid: []string{"secret1"}, invalid: []string{"secret", "SECRET1"}},
+		{complexity: "", valid: []string{"Secret1!"}, invalid: []string{"Secret1"}},
+	}
+	for _, dat := range data {
+		sec := ini.Empty().Section("security")
+		sec.Key("PASSWORD_COMPLEXITY").SetValue(dat.complexity)
+		loadPasswordSettings(sec)
+		for _, pwd := range dat.valid {
+			assert.NoError(t, gModels{}.CheckPasswordPolicy(pwd), "%s: %s", dat.complexity, pwd)
+		}
+		for _, pwd := range dat.invalid {
+			assert.Error(t, gModels{}.CheckPasswordPolicy(pwd), "%s: %s", dat.complexity, pwd)
+		}
+	}
 }
@@ -0,0 +1,66 @@
+package globals
+
+import (
+	"fmt"
+	"strings"
+
+	"code.gitea.io/gitea/modules/setting"
+	"gopkg.in/ini.v1"
+)
+
+var passwordSettingKeys = []string{"MIN_PASSWORD_LENGTH", "PASSWORD_COMPLEXITY", "PASSWORD_HASH_ALGO"}
+
+// passwordComplexities are the character classes of gitea's PASSWORD_COMPLEXITY setting.
+// code.gitea.io/gitea/modules/password is not used since it depends on gitea's web framework
+var passwordComplexities = []struct {
+	name  string
+	chars string
+}{
+	{name: "lower", chars: "abcdefghijklmnopqrstuvwxyz"},
+	{name: "upper", chars: "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+	{name: "digit", chars: "0123456789"},
+	{name: "spec", chars: ` !"#$%&'()*+,-./:;<=>?@[\]^_{|}~` + "`"},
+}
+
+// loadPasswordSettings set gitea's password settings the same way gitea does
+func loadPasswordSettings(sec *ini.Section) {
+	setting.MinPasswordLength = sec.Key("MIN_PASSWORD_LENGTH").MustInt(6)
+	setting.PasswordHashAlgo = sec.Key("PASSWORD_HASH_ALGO").MustString("pbkdf2")
+	setting.PasswordComplexity = []string{}
+	for _, name := range sec.Key("PASSWORD_COMPLEXITY").Strings(",") {
+		if name = strings.ToLower(strings.Trim(name, `"`)); name != "" {
+			setting.PasswordComplexity = append(setting.PasswordComplexity, name)
+		}
+	}
+}
+
+// CheckPasswordPolicy return error if the password does not satisfy gitea's minimum length and complexity
+func (gModels) CheckPasswordPolicy(passwd string) error {
+	if len(passwd) < setting.MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", setting.MinPasswordLength)
+	}
+	values := setting.PasswordComplexity
+	if len(values) == 1 && values[0] == "off" {
+		return nil
+	}
+
+	required := []string{}
+	for _, c := range passwordComplexities {
+		for _, v := range values {
+			if v == c.name {
+				required = append(required, c.chars)
+			}
+		}
+	}
+	if len(required) == 0 {
+		for _, c := range passwordComplexities {
+			required = append(required, c.chars)
+		}
+	}
+	for _, chars := range required {
+		if !strings.ContainsAny(passwd, chars) {
+			return fmt.Errorf("password must contain at least one of %q", chars)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package globals
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/setting"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+// tSQLiteEngine initialize gitea's models using a new sqlite database, and return another engine to populate it
+func tSQLiteEngine(t *testing.T) (e *xorm.Engine, cleanup func()) {
+	dir, err := ioutil.TempDir("", "globals")
+	require.NoError(t, err)
+	setting.EnableSQLite3 = true
+	setting.Database.Type, setting.Database.UseSQLite3 = "sqlite3", true
+	setting.Database.Path, setting.Database.Timeout = filepath.Join(dir, "gitea.db"), 500
+	require.NoError(t, models.NewEngine(context.Background(), func(*xorm.Engine) error { return nil }))
+
+	connStr, err := setting.DBConnStr()
+	require.NoError(t, err)
+	e, err = xorm.NewEngine("sqlite3", connStr)
+	require.NoError(t, err)
+	e.SetMapper(names.GonicMapper{})
+	return e, func() { e.Close(); os.RemoveAll(dir) }
+}
+
+func TestGetRepoUsersByAccessMode(t *testing.T) {
+	e, cleanup := tSQLiteEngine(t)
+	defer cleanup()
+
+	insert := func(beans ...interface{}) {
+		for _, bean := range beans {
+			_, err := e.Insert(bean)
+			require.NoError(t, err)
+		}
+	}
+	user := func(id int64, name string) *models.User {
+		return &models.User{ID: id, Name: name, LowerName: name, Email: name + "@domain.com", IsActive: true}
+	}
+	insert(
+		user(1, "alice"), user(2, "bob"), user(4, "carol"), user(5, "dave"),
+		&models.User{ID: 3, Name: "org", LowerName: "org", Type: models.UserTypeOrganization},
+		&models.Repository{ID: 1, OwnerID: 3, OwnerName: "org", Name: "public", LowerName: "public"},
+		&models.Repository{ID: 2, OwnerID: 1, OwnerName: "alice", Name: "own", LowerName: "own"},
+		&models.Team{ID: 1, OrgID: 3, Name: "readers", LowerName: "readers", Authorize: models.AccessModeRead},
+		&models.Team{ID: 2, OrgID: 3, Name: "issues", LowerName: "issues", Authorize: models.AccessModeRead},
+		&models.TeamUnit{OrgID: 3, TeamID: 1, Type: models.UnitTypeCode},
+		&models.TeamUnit{OrgID: 3, TeamID: 2, Type: models.UnitTypeIssues},
+		&models.TeamUser{OrgID: 3, TeamID: 1, UID: 1},
+		&models.TeamUser{OrgID: 3, TeamID: 2, UID: 5},
+		&models.TeamRepo{OrgID: 3, TeamID: 1, RepoID: 1},
+		&models.TeamRepo{OrgID: 3, TeamID: 2, RepoID: 1},
+		&models.Collaboration{RepoID: 1, UserID: 2, Mode: models.AccessModeRead},
+		&models.Collaboration{RepoID: 1, UserID: 4, Mode: models.AccessModeWrite},
+		&models.Collaboration{RepoID: 2, UserID: 2, Mode: models.AccessModeRead},
+		// public repositories only have write and higher access stored
+		&models.Access{UserID: 4, RepoID: 1, Mode: models.AccessModeWrite},
+	)
+
+	userNames := func(users []*models.User) (names []string) {
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		sort.Strings(names)
+		return
+	}
+	g := gModels{}
+
+	public, err := models.GetRepositoryByID(1)
+	require.NoError(t, err)
+	readers, err := g.GetRepoUsersByAccessMode(public, models.AccessModeRead)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, userNames(readers),
+		"readers of public repository should include collaborators and members of teams having the code unit")
+	writers, err := g.GetRepoUsersByAccessMode(public, models.AccessModeWrite)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"carol"}, userNames(writers))
+
+	own, err := models.GetRepositoryByID(2)
+	require.NoError(t, err)
+	readers, err = g.GetRepoUsersByAccessMode(own, models.AccessModeRead)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob"}, userNames(readers), "readers should include the owner")
+}
@@ -2,9 +2,25 @@ package gitea
 
 import "code.gitea.io/gitea/models"
 
+// Models is the part of Gitea's models used by giteaty. It uses the types of Gitea's models package, so every
+// implementation, including the ones that do not access Gitea's database, links that package
 type Models interface {
 	UserSignIn(username, password string) (*models.User, error)
+	GetAccessTokenBySHA(token string) (*models.AccessToken, error)
+	GetTwoFactorByUID(uid int64) (*models.TwoFactor, error)
+	UpdateTwoFactor(t *models.TwoFactor) error
+	CheckPasswordPolicy(passwd string) error
+	UpdateUserPassword(u *models.User, passwd string) error
 
 	SearchUsers(opts *models.SearchUserOptions) (users []*models.User, count int64, err error)
+	GetUserByName(name string) (*models.User, error)
+	GetUsersByIDs(ids []int64) (models.UserList, error)
 	GetUserTeams(userID int64, listOptions models.ListOptions) ([]*models.Team, error)
+	GetOrgUsersByOrgID(opts *models.FindOrgMembersOpts) ([]*models.OrgUser, error)
+	SearchTeam(opts *models.SearchTeamOptions) ([]*models.Team, int64, error)
+	GetTeam(orgID int64, name string) (*models.Team, error)
+	GetTeamMembers(teamID int64) ([]*models.User, error)
+	ListPublicKeys(uid int64, listOptions models.ListOptions) ([]*models.PublicKey, error)
+	SearchRepository(opts *models.SearchRepoOptions) (models.RepositoryList, int64, error)
+	GetRepoUsersByAccessMode(repo *models.Repository, mode models.AccessMode) ([]*models.User, error)
 }
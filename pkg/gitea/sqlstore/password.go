@@ -0,0 +1,76 @@
+package sqlstore
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// parseHashParams return the parameters following the algorithm name, or the defaults when there are none
+func parseHashParams(params []string, defaults ...int) (values []int, ok bool) {
+	if len(params) == 0 {
+		return defaults, true
+	}
+	if len(params) != len(defaults) {
+		return nil, false
+	}
+	for _, p := range params {
+		v, err := strconv.Atoi(p)
+		if err != nil || v <= 0 {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+// hashPassword hash the password using the algorithm stored in passwd_hash_algo, which is its name optionally
+// followed by its parameters separated by "$", e.g. pbkdf2$50000$50 as stored since Gitea 1.19. Without
+// parameters, the defaults used by every Gitea version apply. It returns false for unknown algorithms
+func hashPassword(passwd, salt, algo string) (hash string, ok bool) {
+	parts := strings.Split(algo, "$")
+	var key []byte
+	switch parts[0] {
+	case "argon2":
+		p, ok := parseHashParams(parts[1:], 2, 65536, 8, 50)
+		if !ok {
+			return "", false
+		}
+		key = argon2.IDKey([]byte(passwd), []byte(salt), uint32(p[0]), uint32(p[1]), uint8(p[2]), uint32(p[3]))
+	case "scrypt":
+		p, ok := parseHashParams(parts[1:], 65536, 16, 2, 50)
+		if !ok {
+			return "", false
+		}
+		var err error
+		if key, err = scrypt.Key([]byte(passwd), []byte(salt), p[0], p[1], p[2], p[3]); err != nil {
+			return "", false
+		}
+	case "pbkdf2", "":
+		p, ok := parseHashParams(parts[1:], 10000, 50)
+		if !ok {
+			return "", false
+		}
+		key = pbkdf2.Key([]byte(passwd), []byte(salt), p[0], p[1], sha256.New)
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%x", key), true
+}
+
+// validatePassword compare the password with the user's hash the same way as Gitea
+func validatePassword(u *models.User, passwd string) bool {
+	if strings.HasPrefix(u.PasswdHashAlgo, "bcrypt") {
+		return bcrypt.CompareHashAndPassword([]byte(u.Passwd), []byte(passwd)) == nil
+	}
+	hash, ok := hashPassword(passwd, u.Salt, u.PasswdHashAlgo)
+	return ok && subtle.ConstantTimeCompare([]byte(u.Passwd), []byte(hash)) == 1
+}
@@ -0,0 +1,54 @@
+package sqlstore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestValidatePassword(t *testing.T) {
+	pw, salt := []byte("secret"), []byte("salt")
+	hex := func(key []byte) string { return fmt.Sprintf("%x", key) }
+	scryptKey := func(n, r, p, l int) []byte {
+		key, err := scrypt.Key(pw, salt, n, r, p, l)
+		require.NoError(t, err)
+		return key
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword(pw, bcrypt.MinCost)
+	require.NoError(t, err)
+
+	data := []struct {
+		scenario string
+		algo     string
+		passwd   string
+		valid    bool
+	}{
+		{scenario: "Empty", algo: "", passwd: hex(pbkdf2.Key(pw, salt, 10000, 50, sha256.New)), valid: true},
+		{scenario: "PBKDF2", algo: "pbkdf2", passwd: hex(pbkdf2.Key(pw, salt, 10000, 50, sha256.New)), valid: true},
+		{scenario: "PBKDF2Parameterised", algo: "pbkdf2$50000$50", passwd: hex(pbkdf2.Key(pw, salt, 50000, 50, sha256.New)), valid: true},
+		{scenario: "PBKDF2WrongParameters", algo: "pbkdf2$50000$50", passwd: hex(pbkdf2.Key(pw, salt, 10000, 50, sha256.New))},
+		{scenario: "Argon2", algo: "argon2", passwd: hex(argon2.IDKey(pw, salt, 2, 65536, 8, 50)), valid: true},
+		{scenario: "Argon2Parameterised", algo: "argon2$1$1024$2$32", passwd: hex(argon2.IDKey(pw, salt, 1, 1024, 2, 32)), valid: true},
+		{scenario: "Scrypt", algo: "scrypt", passwd: hex(scryptKey(65536, 16, 2, 50)), valid: true},
+		{scenario: "ScryptParameterised", algo: "scrypt$1024$8$1$32", passwd: hex(scryptKey(1024, 8, 1, 32)), valid: true},
+		{scenario: "Bcrypt", algo: "bcrypt", passwd: string(bcryptHash), valid: true},
+		{scenario: "BcryptParameterised", algo: "bcrypt$4", passwd: string(bcryptHash), valid: true},
+		{scenario: "MalformedParameters", algo: "pbkdf2$many", passwd: hex(pbkdf2.Key(pw, salt, 10000, 50, sha256.New))},
+		{scenario: "UnknownAlgorithm", algo: "md5", passwd: hex(pbkdf2.Key(pw, salt, 10000, 50, sha256.New))},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			u := &models.User{Passwd: dat.passwd, Salt: string(salt), PasswdHashAlgo: dat.algo}
+			assert.Equal(t, dat.valid, validatePassword(u, "secret"))
+			assert.False(t, validatePassword(u, "invalid"))
+		})
+	}
+}
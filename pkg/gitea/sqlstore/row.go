@@ -0,0 +1,51 @@
+package sqlstore
+
+import (
+	"strconv"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+// querier select rows of a table. Rows match every column of eq, where a slice value matches any of its elements
+type querier interface {
+	find(table string, eq builder.Eq) ([]row, error)
+}
+
+// engine select every column, so that columns added or removed by Gitea's migrations do not break the query
+type engine struct {
+	*xorm.Engine
+}
+
+func (e engine) find(table string, eq builder.Eq) (rows []row, err error) {
+	res, err := e.QueryString(builder.Select("*").From("`" + table + "`").Where(eq))
+	if err != nil {
+		return
+	}
+	rows = make([]row, 0, len(res))
+	for _, r := range res {
+		rows = append(rows, r)
+	}
+	return
+}
+
+// row maps column names to their values. Missing columns, e.g. ones not yet added in the schema version, have zero value
+type row map[string]string
+
+func (r row) str(col string) string {
+	return r[col]
+}
+
+func (r row) int64(col string) int64 {
+	v, _ := strconv.ParseInt(r[col], 10, 64)
+	return v
+}
+
+func (r row) int(col string) int {
+	return int(r.int64(col))
+}
+
+func (r row) bool(col string) bool {
+	v, _ := strconv.ParseBool(r[col])
+	return v
+}
@@ -0,0 +1,108 @@
+package sqlstore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/models/migrations"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+)
+
+// tSQLiteDSN create a sqlite database with the tables of gitea's models, and return its data source name
+func tSQLiteDSN(t *testing.T, beans ...interface{}) (dsn string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	require.NoError(t, err)
+	dsn = "file:" + filepath.Join(dir, "gitea.db")
+	x, err := xorm.NewEngine("sqlite3", dsn)
+	require.NoError(t, err)
+	defer x.Close()
+	x.SetMapper(names.GonicMapper{})
+
+	require.NoError(t, x.Sync2(
+		new(migrations.Version), new(models.User), new(models.Team), new(models.TeamUser), new(models.OrgUser),
+		new(models.AccessToken), new(models.TwoFactor), new(models.PublicKey),
+	))
+	for _, bean := range beans {
+		_, err := x.Insert(bean)
+		require.NoError(t, err)
+	}
+	return dsn, func() { os.RemoveAll(dir) }
+}
+
+func TestEngine(t *testing.T) {
+	dsn, cleanup := tSQLiteDSN(t,
+		&migrations.Version{ID: 1, Version: migrations.ExpectedVersion()},
+		&models.User{ID: 1, Name: "Alice", LowerName: "alice", Email: "alice@domain.com", Passwd: hashToken("secret", "salt"), Salt: "salt", IsActive: true},
+		&models.User{ID: 2, Name: "bob", LowerName: "bob", Email: "bob@domain.com", IsActive: true},
+		&models.User{ID: 3, Name: "org", LowerName: "org", Type: models.UserTypeOrganization, IsActive: true},
+		&models.Team{ID: 1, OrgID: 3, Name: "Owners", LowerName: "owners", Authorize: models.AccessModeOwner},
+		&models.TeamUser{OrgID: 3, TeamID: 1, UID: 2},
+		&models.AccessToken{UID: 2, Name: "token", TokenHash: hashToken("fedcba9889abcdef", "salt"), TokenSalt: "salt", TokenLastEight: "89abcdef"},
+	)
+	defer cleanup()
+
+	s, err := New("sqlite3", dsn)
+	require.NoError(t, err)
+	assert.Equal(t, migrations.ExpectedVersion(), s.SchemaVersion())
+
+	u, err := s.UserSignIn("alice", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.ID)
+	assert.True(t, u.IsActive)
+	_, err = s.UserSignIn("alice", "invalid")
+	assert.True(t, models.IsErrUserNotExist(err))
+
+	users, err := s.GetUsersByIDs([]int64{1, 2, 4})
+	require.NoError(t, err, "slice values should be queried using IN")
+	require.Len(t, users, 2)
+	assert.Equal(t, "Alice", users[0].Name)
+
+	orgs, _, err := s.SearchUsers(&models.SearchUserOptions{Type: models.UserTypeOrganization})
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, int64(3), orgs[0].ID)
+
+	teams, err := s.GetUserTeams(2, models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, teams, 1)
+	assert.Equal(t, models.AccessModeOwner, teams[0].Authorize)
+
+	token, err := s.GetAccessTokenBySHA("fedcba9889abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), token.UID)
+}
+
+// TestEngineNewerSchema use a schema newer than the linked models, whose users have a column unknown to them
+// and a password hashed using parameters stored since Gitea 1.19
+func TestEngineNewerSchema(t *testing.T) {
+	dsn, cleanup := tSQLiteDSN(t,
+		&migrations.Version{ID: 1, Version: 300},
+		&models.User{ID: 1, Name: "alice", LowerName: "alice", Email: "alice@domain.com", IsActive: true, Salt: "salt",
+			Passwd: fmt.Sprintf("%x", pbkdf2.Key([]byte("secret"), []byte("salt"), 50000, 50, sha256.New)), PasswdHashAlgo: "pbkdf2$50000$50"},
+	)
+	defer cleanup()
+	x, err := xorm.NewEngine("sqlite3", dsn)
+	require.NoError(t, err)
+	_, err = x.Exec("ALTER TABLE `user` ADD COLUMN `keep_activity_private` BOOLEAN DEFAULT 0")
+	x.Close()
+	require.NoError(t, err)
+
+	s, err := New("sqlite3", dsn)
+	require.NoError(t, err, "should accept newer schema")
+	assert.Equal(t, int64(300), s.SchemaVersion())
+	u, err := s.UserSignIn("alice", "secret")
+	require.NoError(t, err, "should verify parameterised password hash")
+	assert.Equal(t, int64(1), u.ID)
+	_, err = s.UserSignIn("alice", "invalid")
+	assert.True(t, models.IsErrUserNotExist(err))
+}
@@ -0,0 +1,480 @@
+// Package sqlstore implements gitea.Models by reading only the tables needed by giteaty from Gitea's database.
+// Rows are mapped by column name, so that the same build works with a range of Gitea's schema versions,
+// and nothing is ever written to the database
+package sqlstore
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/rucciva/giteaty/pkg/gitea"
+	"golang.org/x/crypto/pbkdf2"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+var (
+	_ gitea.Models = &Store{}
+
+	// ErrReadOnly is returned by operations that would modify Gitea's database
+	ErrReadOnly = errors.New("gitea database is read only")
+	// ErrNotSupported is returned by operations that need tables not read by Store
+	ErrNotSupported = errors.New("not supported by read only gitea database")
+)
+
+const (
+	// MinSchemaVersion is the schema version of Gitea 1.9, since which access tokens are hashed
+	MinSchemaVersion = 86
+
+	// keyTypePrincipal is the type of ssh principals stored with public keys since Gitea 1.13
+	keyTypePrincipal = 3
+)
+
+type option = func(s *Store) error
+
+func Options() []option {
+	return make([]func(s *Store) error, 0, 3)
+}
+
+func WithMaxOpenConns(n int) option {
+	return func(s *Store) (err error) {
+		s.maxOpenConns = n
+		return
+	}
+}
+
+func WithMaxIdleConns(n int) option {
+	return func(s *Store) (err error) {
+		s.maxIdleConns = n
+		return
+	}
+}
+
+func WithConnMaxLifetime(d time.Duration) option {
+	return func(s *Store) (err error) {
+		s.connMaxLifetime = d
+		return
+	}
+}
+
+type Store struct {
+	q       querier
+	version int64
+
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+}
+
+// New connect to Gitea's database and detect its schema version. The driver is one registered by
+// Gitea's models package, i.e. mysql, postgres, mssql, or sqlite3 when built with the sqlite tag
+func New(driver, dsn string, opts ...option) (s *Store, err error) {
+	s = &Store{}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return
+		}
+	}
+	x, err := xorm.NewEngine(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect to database failed: %w", err)
+	}
+	if s.maxOpenConns > 0 {
+		x.SetMaxOpenConns(s.maxOpenConns)
+	}
+	if s.maxIdleConns > 0 {
+		x.SetMaxIdleConns(s.maxIdleConns)
+	}
+	if s.connMaxLifetime > 0 {
+		x.SetConnMaxLifetime(s.connMaxLifetime)
+	}
+	s.q = engine{x}
+	if err = s.detectVersion(); err != nil {
+		x.Close()
+		return nil, err
+	}
+	return
+}
+
+// detectVersion read the version recorded by Gitea's migrations. Newer versions are accepted since
+// Gitea's migrations keep the columns read by Store, and the password hash formats they add are verified by validatePassword
+func (s *Store) detectVersion() (err error) {
+	rows, err := s.q.find("version", builder.Eq{"id": 1})
+	if err != nil {
+		return fmt.Errorf("read schema version failed: %w", err)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("schema version not found, gitea database is not initialized")
+	}
+	s.version = rows[0].int64("version")
+	if s.version < MinSchemaVersion {
+		return fmt.Errorf("schema version %d is not supported, the minimum is %d (gitea 1.9)", s.version, MinSchemaVersion)
+	}
+	return
+}
+
+// SchemaVersion return the schema version of Gitea's database
+func (s *Store) SchemaVersion() int64 {
+	return s.version
+}
+
+func toUser(r row) *models.User {
+	return &models.User{
+		ID:               r.int64("id"),
+		LowerName:        r.str("lower_name"),
+		Name:             r.str("name"),
+		FullName:         r.str("full_name"),
+		Email:            r.str("email"),
+		KeepEmailPrivate: r.bool("keep_email_private"),
+		Passwd:           r.str("passwd"),
+		PasswdHashAlgo:   r.str("passwd_hash_algo"),
+		Salt:             r.str("salt"),
+		LoginType:        models.LoginType(r.int("login_type")),
+		LoginSource:      r.int64("login_source"),
+		LoginName:        r.str("login_name"),
+		Type:             models.UserType(r.int("type")),
+		Description:      r.str("description"),
+		CreatedUnix:      timeutil.TimeStamp(r.int64("created_unix")),
+		UpdatedUnix:      timeutil.TimeStamp(r.int64("updated_unix")),
+		IsActive:         r.bool("is_active"),
+		IsAdmin:          r.bool("is_admin"),
+		IsRestricted:     r.bool("is_restricted"),
+		ProhibitLogin:    r.bool("prohibit_login"),
+		Visibility:       structs.VisibleType(r.int("visibility")),
+		NumTeams:         r.int("num_teams"),
+		NumMembers:       r.int("num_members"),
+	}
+}
+
+func toTeam(r row) *models.Team {
+	return &models.Team{
+		ID:                      r.int64("id"),
+		OrgID:                   r.int64("org_id"),
+		LowerName:               r.str("lower_name"),
+		Name:                    r.str("name"),
+		Description:             r.str("description"),
+		Authorize:               models.AccessMode(r.int("authorize")),
+		NumRepos:                r.int("num_repos"),
+		NumMembers:              r.int("num_members"),
+		IncludesAllRepositories: r.bool("includes_all_repositories"),
+		CanCreateOrgRepo:        r.bool("can_create_org_repo"),
+	}
+}
+
+func (s *Store) findUsers(eq builder.Eq) (users []*models.User, err error) {
+	rows, err := s.q.find("user", eq)
+	if err != nil {
+		return
+	}
+	users = make([]*models.User, 0, len(rows))
+	for _, r := range rows {
+		users = append(users, toUser(r))
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return
+}
+
+func (s *Store) findTeams(eq builder.Eq) (teams []*models.Team, err error) {
+	rows, err := s.q.find("team", eq)
+	if err != nil {
+		return
+	}
+	teams = make([]*models.Team, 0, len(rows))
+	for _, r := range rows {
+		teams = append(teams, toTeam(r))
+	}
+	sort.SliceStable(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return
+}
+
+// findIDs return the values of the column of matching rows
+func (s *Store) findIDs(table, col string, eq builder.Eq) (ids []int64, err error) {
+	rows, err := s.q.find(table, eq)
+	if err != nil {
+		return
+	}
+	ids = make([]int64, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.int64(col))
+	}
+	return
+}
+
+// UserSignIn verify the password of local users the same way as Gitea, including hash formats added after the
+// linked models, but without upgrading the password hash.
+// Users of other login sources, e.g. LDAP, can not sign in
+func (s *Store) UserSignIn(username, password string) (*models.User, error) {
+	var eq builder.Eq
+	if strings.Contains(username, "@") {
+		eq = builder.Eq{"email": strings.ToLower(strings.TrimSpace(username))}
+	} else if trimmed := strings.TrimSpace(username); trimmed != "" {
+		eq = builder.Eq{"lower_name": strings.ToLower(trimmed)}
+	} else {
+		return nil, models.ErrUserNotExist{Name: username}
+	}
+	users, err := s.findUsers(eq)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(users) == 0:
+		return nil, models.ErrUserNotExist{Name: username}
+	case len(users) > 1:
+		return nil, models.ErrEmailAlreadyUsed{Email: username}
+	}
+
+	user := users[0]
+	switch user.LoginType {
+	case models.LoginNoType, models.LoginPlain, models.LoginOAuth2:
+	default:
+		return nil, models.ErrUnsupportedLoginType
+	}
+	if !user.IsPasswordSet() || !validatePassword(user, password) {
+		return nil, models.ErrUserNotExist{UID: user.ID, Name: user.Name}
+	}
+	if user.ProhibitLogin {
+		return nil, models.ErrUserProhibitLogin{UID: user.ID, Name: user.Name}
+	}
+	return user, nil
+}
+
+func hashToken(token, salt string) string {
+	return fmt.Sprintf("%x", pbkdf2.Key([]byte(token), []byte(salt), 10000, 50, sha256.New))
+}
+
+func (s *Store) GetAccessTokenBySHA(token string) (*models.AccessToken, error) {
+	if token == "" {
+		return nil, models.ErrAccessTokenEmpty{}
+	}
+	if len(token) < 8 {
+		return nil, models.ErrAccessTokenNotExist{Token: token}
+	}
+	rows, err := s.q.find("access_token", builder.Eq{"token_last_eight": token[len(token)-8:]})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		if subtle.ConstantTimeCompare([]byte(r.str("token_hash")), []byte(hashToken(token, r.str("token_salt")))) == 1 {
+			return &models.AccessToken{
+				ID:             r.int64("id"),
+				UID:            r.int64("uid"),
+				Name:           r.str("name"),
+				TokenHash:      r.str("token_hash"),
+				TokenSalt:      r.str("token_salt"),
+				TokenLastEight: r.str("token_last_eight"),
+				CreatedUnix:    timeutil.TimeStamp(r.int64("created_unix")),
+				UpdatedUnix:    timeutil.TimeStamp(r.int64("updated_unix")),
+			}, nil
+		}
+	}
+	return nil, models.ErrAccessTokenNotExist{Token: token}
+}
+
+func (s *Store) GetTwoFactorByUID(uid int64) (*models.TwoFactor, error) {
+	rows, err := s.q.find("two_factor", builder.Eq{"uid": uid})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, models.ErrTwoFactorNotEnrolled{UID: uid}
+	}
+	r := rows[0]
+	return &models.TwoFactor{
+		ID:               r.int64("id"),
+		UID:              r.int64("uid"),
+		Secret:           r.str("secret"),
+		ScratchSalt:      r.str("scratch_salt"),
+		ScratchHash:      r.str("scratch_hash"),
+		LastUsedPasscode: r.str("last_used_passcode"),
+		CreatedUnix:      timeutil.TimeStamp(r.int64("created_unix")),
+		UpdatedUnix:      timeutil.TimeStamp(r.int64("updated_unix")),
+	}, nil
+}
+
+func (s *Store) UpdateTwoFactor(t *models.TwoFactor) error {
+	return ErrReadOnly
+}
+
+func (s *Store) CheckPasswordPolicy(passwd string) error {
+	return ErrReadOnly
+}
+
+func (s *Store) UpdateUserPassword(u *models.User, passwd string) error {
+	return ErrReadOnly
+}
+
+// SearchUsers filter users the same way as Gitea, except that Actor and the paging options are ignored
+func (s *Store) SearchUsers(opts *models.SearchUserOptions) (users []*models.User, count int64, err error) {
+	eq := builder.Eq{"type": int(opts.Type), "visibility": int(structs.VisibleTypePublic)}
+	if len(opts.Visible) > 0 {
+		visible := make([]int, 0, len(opts.Visible))
+		for _, v := range opts.Visible {
+			visible = append(visible, int(v))
+		}
+		eq["visibility"] = visible
+	}
+	if opts.UID > 0 {
+		eq["id"] = opts.UID
+	}
+	if !opts.IsActive.IsNone() {
+		eq["is_active"] = opts.IsActive.IsTrue()
+	}
+	found, err := s.findUsers(eq)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kw := strings.ToLower(opts.Keyword)
+	for _, u := range found {
+		if kw == "" || strings.Contains(u.LowerName, kw) || strings.Contains(strings.ToLower(u.FullName), kw) ||
+			(opts.SearchByEmail && strings.Contains(strings.ToLower(u.Email), kw)) {
+			users = append(users, u)
+		}
+	}
+	return users, int64(len(users)), nil
+}
+
+func (s *Store) GetUserByName(name string) (*models.User, error) {
+	if name == "" {
+		return nil, models.ErrUserNotExist{Name: name}
+	}
+	users, err := s.findUsers(builder.Eq{"lower_name": strings.ToLower(name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, models.ErrUserNotExist{Name: name}
+	}
+	return users[0], nil
+}
+
+func (s *Store) GetUsersByIDs(ids []int64) (models.UserList, error) {
+	if len(ids) == 0 {
+		return models.UserList{}, nil
+	}
+	return s.findUsers(builder.Eq{"id": ids})
+}
+
+// GetUserTeams return every team of the user, the paging options are ignored
+func (s *Store) GetUserTeams(userID int64, listOptions models.ListOptions) ([]*models.Team, error) {
+	ids, err := s.findIDs("team_user", "team_id", builder.Eq{"uid": userID})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return s.findTeams(builder.Eq{"id": ids})
+}
+
+// GetOrgUsersByOrgID return every member of the organization, the paging options are ignored
+func (s *Store) GetOrgUsersByOrgID(opts *models.FindOrgMembersOpts) ([]*models.OrgUser, error) {
+	eq := builder.Eq{"org_id": opts.OrgID}
+	if opts.PublicOnly {
+		eq["is_public"] = true
+	}
+	rows, err := s.q.find("org_user", eq)
+	if err != nil {
+		return nil, err
+	}
+	ous := make([]*models.OrgUser, 0, len(rows))
+	for _, r := range rows {
+		ous = append(ous, &models.OrgUser{
+			ID:       r.int64("id"),
+			UID:      r.int64("uid"),
+			OrgID:    r.int64("org_id"),
+			IsPublic: r.bool("is_public"),
+		})
+	}
+	return ous, nil
+}
+
+// SearchTeam filter teams of the organization the same way as Gitea, except that the paging options are ignored
+func (s *Store) SearchTeam(opts *models.SearchTeamOptions) ([]*models.Team, int64, error) {
+	eq := builder.Eq{"org_id": opts.OrgID}
+	if opts.UserID > 0 {
+		ids, err := s.findIDs("team_user", "team_id", builder.Eq{"uid": opts.UserID, "org_id": opts.OrgID})
+		if err != nil || len(ids) == 0 {
+			return nil, 0, err
+		}
+		eq["id"] = ids
+	}
+	found, err := s.findTeams(eq)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kw := strings.ToLower(opts.Keyword)
+	teams := make([]*models.Team, 0, len(found))
+	for _, t := range found {
+		if kw == "" || strings.Contains(t.LowerName, kw) ||
+			(opts.IncludeDesc && strings.Contains(strings.ToLower(t.Description), kw)) {
+			teams = append(teams, t)
+		}
+	}
+	return teams, int64(len(teams)), nil
+}
+
+func (s *Store) GetTeam(orgID int64, name string) (*models.Team, error) {
+	teams, err := s.findTeams(builder.Eq{"org_id": orgID, "lower_name": strings.ToLower(name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		return nil, models.ErrTeamNotExist{OrgID: orgID, Name: name}
+	}
+	return teams[0], nil
+}
+
+func (s *Store) GetTeamMembers(teamID int64) ([]*models.User, error) {
+	ids, err := s.findIDs("team_user", "uid", builder.Eq{"team_id": teamID})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	users, err := s.findUsers(builder.Eq{"id": ids})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].DisplayName() < users[j].DisplayName() })
+	return users, nil
+}
+
+// ListPublicKeys return every ssh public key of the user, the paging options are ignored
+func (s *Store) ListPublicKeys(uid int64, listOptions models.ListOptions) ([]*models.PublicKey, error) {
+	rows, err := s.q.find("public_key", builder.Eq{"owner_id": uid})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*models.PublicKey, 0, len(rows))
+	for _, r := range rows {
+		if r.int("type") == keyTypePrincipal {
+			continue
+		}
+		keys = append(keys, &models.PublicKey{
+			ID:            r.int64("id"),
+			OwnerID:       r.int64("owner_id"),
+			Name:          r.str("name"),
+			Fingerprint:   r.str("fingerprint"),
+			Content:       r.str("content"),
+			Mode:          models.AccessMode(r.int("mode")),
+			Type:          models.KeyType(r.int("type")),
+			LoginSourceID: r.int64("login_source_id"),
+			CreatedUnix:   timeutil.TimeStamp(r.int64("created_unix")),
+			UpdatedUnix:   timeutil.TimeStamp(r.int64("updated_unix")),
+		})
+	}
+	return keys, nil
+}
+
+func (s *Store) SearchRepository(opts *models.SearchRepoOptions) (models.RepositoryList, int64, error) {
+	return nil, 0, ErrNotSupported
+}
+
+func (s *Store) GetRepoUsersByAccessMode(repo *models.Repository, mode models.AccessMode) ([]*models.User, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,217 @@
+package sqlstore
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"xorm.io/builder"
+)
+
+// tQuerier is an in-memory stand-in of Gitea's database
+type tQuerier map[string][]row
+
+func (q tQuerier) find(table string, eq builder.Eq) (rows []row, err error) {
+	match := func(r row) bool {
+		for col, v := range eq {
+			values := reflect.ValueOf(v)
+			if values.Kind() != reflect.Slice {
+				values = reflect.ValueOf([]interface{}{v})
+			}
+			found := false
+			for i := 0; i < values.Len(); i++ {
+				found = found || r[col] == fmt.Sprint(values.Index(i).Interface())
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	for _, r := range q[table] {
+		if match(r) {
+			rows = append(rows, r)
+		}
+	}
+	return
+}
+
+// newTStore return a store of a database with schema version 117 (gitea 1.11), which has neither the
+// passwd_hash_algo nor the is_restricted column of users
+func newTStore(t *testing.T) *Store {
+	q := tQuerier{
+		"version": {{"id": "1", "version": "117"}},
+		"user": {
+			{"id": "1", "lower_name": "alice", "name": "Alice", "email": "alice@domain.com", "passwd": hashToken("secret", "salt"), "salt": "salt", "type": "0", "visibility": "0", "is_active": "true", "is_admin": "true"},
+			{"id": "2", "lower_name": "bob", "name": "bob", "full_name": "Bob", "email": "bob@domain.com", "passwd": hashToken("secret", "salt"), "salt": "salt", "type": "0", "visibility": "0", "is_active": "true", "prohibit_login": "true"},
+			{"id": "3", "lower_name": "carol", "name": "carol", "email": "carol@domain.com", "login_type": "2", "type": "0", "visibility": "2", "is_active": "false"},
+			{"id": "4", "lower_name": "org", "name": "org", "type": "1", "visibility": "0", "is_active": "true"},
+		},
+		"team":      {{"id": "5", "org_id": "4", "lower_name": "owners", "name": "Owners", "authorize": "4"}},
+		"team_user": {{"id": "1", "org_id": "4", "team_id": "5", "uid": "2"}, {"id": "2", "org_id": "4", "team_id": "5", "uid": "1"}},
+		"org_user":  {{"id": "1", "uid": "2", "org_id": "4", "is_public": "false"}},
+		"access_token": {
+			{"id": "1", "uid": "1", "token_hash": hashToken("0123456789abcdef", "other"), "token_salt": "other", "token_last_eight": "89abcdef"},
+			{"id": "2", "uid": "2", "token_hash": hashToken("fedcba9889abcdef", "salt"), "token_salt": "salt", "token_last_eight": "89abcdef"},
+		},
+		"two_factor": {{"id": "1", "uid": "1", "secret": "secret", "last_used_passcode": "123456"}},
+		"public_key": {
+			{"id": "1", "owner_id": "2", "name": "laptop", "content": "ssh-ed25519 AAAA", "type": "1", "mode": "2"},
+			{"id": "2", "owner_id": "2", "name": "principal", "content": "bob@domain.com", "type": "3", "mode": "2"},
+		},
+	}
+	s := &Store{q: q}
+	require.NoError(t, s.detectVersion())
+	return s
+}
+
+func TestDetectVersion(t *testing.T) {
+	s := newTStore(t)
+	assert.Equal(t, int64(117), s.SchemaVersion())
+
+	s = &Store{q: tQuerier{"version": {{"id": "1", "version": "85"}}}}
+	assert.Error(t, s.detectVersion(), "should reject schema older than the minimum")
+	s = &Store{q: tQuerier{}}
+	assert.Error(t, s.detectVersion(), "should reject uninitialized database")
+	s = &Store{q: tQuerier{"version": {{"id": "1", "version": "999"}}}}
+	assert.NoError(t, s.detectVersion(), "should accept newer schema")
+}
+
+func TestUserSignIn(t *testing.T) {
+	s := newTStore(t)
+
+	u, err := s.UserSignIn("ALICE", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.ID)
+	assert.True(t, u.IsAdmin)
+	assert.False(t, u.IsRestricted, "missing column should have zero value")
+	u, err = s.UserSignIn("alice@domain.com", "secret")
+	require.NoError(t, err, "should sign in using email")
+	assert.Equal(t, int64(1), u.ID)
+
+	_, err = s.UserSignIn("alice", "invalid")
+	assert.True(t, models.IsErrUserNotExist(err))
+	_, err = s.UserSignIn("dave", "secret")
+	assert.True(t, models.IsErrUserNotExist(err))
+	_, err = s.UserSignIn("bob", "secret")
+	assert.True(t, models.IsErrUserProhibitLogin(err))
+	_, err = s.UserSignIn("carol", "secret")
+	assert.Equal(t, models.ErrUnsupportedLoginType, err)
+}
+
+func TestGetAccessTokenBySHA(t *testing.T) {
+	s := newTStore(t)
+
+	token, err := s.GetAccessTokenBySHA("fedcba9889abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), token.UID)
+
+	_, err = s.GetAccessTokenBySHA("0000000089abcdef")
+	assert.True(t, models.IsErrAccessTokenNotExist(err))
+	_, err = s.GetAccessTokenBySHA("short")
+	assert.True(t, models.IsErrAccessTokenNotExist(err))
+	_, err = s.GetAccessTokenBySHA("")
+	assert.True(t, models.IsErrAccessTokenEmpty(err))
+}
+
+func TestTwoFactor(t *testing.T) {
+	s := newTStore(t)
+
+	twofa, err := s.GetTwoFactorByUID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "123456", twofa.LastUsedPasscode)
+	_, err = s.GetTwoFactorByUID(2)
+	assert.True(t, models.IsErrTwoFactorNotEnrolled(err))
+
+	assert.Equal(t, ErrReadOnly, s.UpdateTwoFactor(twofa))
+	assert.Equal(t, ErrReadOnly, s.UpdateUserPassword(&models.User{ID: 1}, "secret"))
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := newTStore(t)
+
+	users, count, err := s.SearchUsers(&models.SearchUserOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count, "should only list public individual users")
+	assert.Equal(t, "Alice", users[0].Name)
+	assert.Equal(t, "bob", users[1].Name)
+
+	users, _, err = s.SearchUsers(&models.SearchUserOptions{Keyword: "BO"})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, int64(2), users[0].ID)
+
+	users, _, err = s.SearchUsers(&models.SearchUserOptions{Keyword: "alice@", SearchByEmail: true})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, int64(1), users[0].ID)
+
+	orgs, _, err := s.SearchUsers(&models.SearchUserOptions{Type: models.UserTypeOrganization})
+	require.NoError(t, err)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, "org", orgs[0].Name)
+
+	users, err = s.GetUsersByIDs([]int64{2, 3})
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+
+	u, err := s.GetUserByName("Carol")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), u.ID)
+	assert.False(t, u.IsActive)
+	_, err = s.GetUserByName("dave")
+	assert.True(t, models.IsErrUserNotExist(err))
+}
+
+func TestTeams(t *testing.T) {
+	s := newTStore(t)
+
+	teams, err := s.GetUserTeams(2, models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, teams, 1)
+	assert.Equal(t, int64(4), teams[0].OrgID)
+	assert.Equal(t, models.AccessModeOwner, teams[0].Authorize)
+	teams, err = s.GetUserTeams(3, models.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, teams)
+
+	teams, count, err := s.SearchTeam(&models.SearchTeamOptions{OrgID: 4, Keyword: "own"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	teams, _, err = s.SearchTeam(&models.SearchTeamOptions{OrgID: 4, UserID: 3})
+	require.NoError(t, err)
+	assert.Empty(t, teams)
+
+	team, err := s.GetTeam(4, "Owners")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), team.ID)
+	_, err = s.GetTeam(4, "developers")
+	assert.True(t, models.IsErrTeamNotExist(err))
+
+	members, err := s.GetTeamMembers(5)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "Alice", members[0].Name)
+	assert.Equal(t, "bob", members[1].Name)
+
+	ous, err := s.GetOrgUsersByOrgID(&models.FindOrgMembersOpts{OrgID: 4})
+	require.NoError(t, err)
+	require.Len(t, ous, 1)
+	assert.Equal(t, int64(2), ous[0].UID)
+	ous, err = s.GetOrgUsersByOrgID(&models.FindOrgMembersOpts{OrgID: 4, PublicOnly: true})
+	require.NoError(t, err)
+	assert.Empty(t, ous)
+}
+
+func TestListPublicKeys(t *testing.T) {
+	s := newTStore(t)
+
+	keys, err := s.ListPublicKeys(2, models.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, keys, 1, "should exclude principals")
+	assert.Equal(t, "ssh-ed25519 AAAA", keys[0].Content)
+	assert.Equal(t, models.KeyType(models.KeyTypeUser), keys[0].Type)
+}
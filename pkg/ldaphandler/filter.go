@@ -0,0 +1,256 @@
+package ldaphandler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nmcclain/ldap"
+)
+
+type filterType int
+
+const (
+	filterAnd filterType = iota
+	filterOr
+	filterNot
+	filterEquality
+	filterSubstrings
+	filterGreaterOrEqual
+	filterLessOrEqual
+	filterPresent
+	filterApprox
+)
+
+// filter is the parsed form of an RFC 4515 search filter
+type filter struct {
+	typ      filterType
+	children []*filter
+
+	attr  string
+	value string
+
+	initial string
+	any     []string
+	final   string
+}
+
+func parseFilter(s string) (f *filter, err error) {
+	if len(s) == 0 || s[0] != '(' {
+		return nil, fmt.Errorf("filter '%s' does not start with '('", s)
+	}
+	f, pos, err := parseFilterAt(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(s) {
+		return nil, fmt.Errorf("unexpected '%s' at the end of filter", s[pos:])
+	}
+	return
+}
+
+// parseFilterAt parse the filter which opening parenthesis located at pos,
+// and return position right after its closing parenthesis
+func parseFilterAt(s string, pos int) (f *filter, next int, err error) {
+	if pos >= len(s) || s[pos] != '(' {
+		return nil, pos, fmt.Errorf("expecting '(' at position %d", pos)
+	}
+	pos++
+	if pos >= len(s) {
+		return nil, pos, fmt.Errorf("unexpected end of filter")
+	}
+
+	switch s[pos] {
+	case '&', '|':
+		f = &filter{typ: filterAnd}
+		if s[pos] == '|' {
+			f.typ = filterOr
+		}
+		pos++
+		for pos < len(s) && s[pos] == '(' {
+			var child *filter
+			if child, pos, err = parseFilterAt(s, pos); err != nil {
+				return
+			}
+			f.children = append(f.children, child)
+		}
+
+	case '!':
+		var child *filter
+		if child, pos, err = parseFilterAt(s, pos+1); err != nil {
+			return
+		}
+		f = &filter{typ: filterNot, children: []*filter{child}}
+
+	default:
+		end := strings.IndexByte(s[pos:], ')')
+		if end < 0 {
+			return nil, pos, fmt.Errorf("unexpected end of filter")
+		}
+		if f, err = parseFilterItem(s[pos : pos+end]); err != nil {
+			return
+		}
+		pos += end
+	}
+
+	if pos >= len(s) || s[pos] != ')' {
+		return nil, pos, fmt.Errorf("expecting ')' at position %d", pos)
+	}
+	return f, pos + 1, nil
+}
+
+func parseFilterItem(item string) (f *filter, err error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("invalid filter item '%s'", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	f = &filter{typ: filterEquality}
+	switch attr[len(attr)-1] {
+	case '>':
+		f.typ, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		f.typ, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		f.typ, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr = strings.TrimSpace(attr); attr == "" {
+		return nil, fmt.Errorf("invalid filter item '%s'", item)
+	}
+	f.attr = strings.ToLower(attr)
+
+	if f.typ != filterEquality || !strings.Contains(value, "*") {
+		f.value, err = unescapeFilterValue(value)
+		return
+	}
+	if value == "*" {
+		f.typ = filterPresent
+		return
+	}
+
+	f.typ = filterSubstrings
+	parts := strings.Split(value, "*")
+	for i, part := range parts {
+		if part, err = unescapeFilterValue(part); err != nil {
+			return
+		}
+		switch {
+		case i == 0:
+			f.initial = part
+		case i == len(parts)-1:
+			f.final = part
+		case part != "":
+			f.any = append(f.any, part)
+		}
+	}
+	return
+}
+
+// unescapeFilterValue decode '\XX' hex escape as defined by RFC 4515
+func unescapeFilterValue(v string) (string, error) {
+	if !strings.Contains(v, `\`) {
+		return v, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			sb.WriteByte(v[i])
+			continue
+		}
+		if i+2 >= len(v) {
+			return "", fmt.Errorf("invalid escape sequence in '%s'", v)
+		}
+		b, err := hex.DecodeString(v[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence in '%s': %w", v, err)
+		}
+		sb.Write(b)
+		i += 2
+	}
+	return sb.String(), nil
+}
+
+func (f *filter) match(entry *ldap.Entry) bool {
+	switch f.typ {
+	case filterAnd:
+		for _, child := range f.children {
+			if !child.match(entry) {
+				return false
+			}
+		}
+		return true
+
+	case filterOr:
+		for _, child := range f.children {
+			if child.match(entry) {
+				return true
+			}
+		}
+		return false
+
+	case filterNot:
+		return !f.children[0].match(entry)
+	}
+
+	for _, attr := range entry.Attributes {
+		if !strings.EqualFold(attr.Name, f.attr) {
+			continue
+		}
+		if f.typ == filterPresent {
+			return true
+		}
+		for _, v := range attr.Values {
+			if f.matchValue(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *filter) matchValue(v string) bool {
+	switch f.typ {
+	case filterEquality, filterApprox:
+		return strings.EqualFold(v, f.value)
+
+	case filterGreaterOrEqual:
+		return compareValue(v, f.value) >= 0
+
+	case filterLessOrEqual:
+		return compareValue(v, f.value) <= 0
+
+	case filterSubstrings:
+		v = strings.ToLower(v)
+		if !strings.HasPrefix(v, strings.ToLower(f.initial)) {
+			return false
+		}
+		v = v[len(f.initial):]
+		for _, sub := range f.any {
+			i := strings.Index(v, strings.ToLower(sub))
+			if i < 0 {
+				return false
+			}
+			v = v[i+len(sub):]
+		}
+		return strings.HasSuffix(v, strings.ToLower(f.final))
+	}
+	return false
+}
+
+// compareValue compare numerically when both values are integer, otherwise compare case-insensitively
+func compareValue(a, b string) int {
+	if x, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if y, err := strconv.ParseInt(b, 10, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
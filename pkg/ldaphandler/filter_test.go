@@ -0,0 +1,93 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	data := []struct {
+		i string
+		f *filter
+	}{
+		{i: "(uid=a)", f: &filter{typ: filterEquality, attr: "uid", value: "a"}},
+		{i: "(UID=A)", f: &filter{typ: filterEquality, attr: "uid", value: "A"}},
+		{i: "(uid=*)", f: &filter{typ: filterPresent, attr: "uid"}},
+		{i: "(uid>=10)", f: &filter{typ: filterGreaterOrEqual, attr: "uid", value: "10"}},
+		{i: "(uid<=10)", f: &filter{typ: filterLessOrEqual, attr: "uid", value: "10"}},
+		{i: "(uid~=a)", f: &filter{typ: filterApprox, attr: "uid", value: "a"}},
+		{i: "(cn=a*)", f: &filter{typ: filterSubstrings, attr: "cn", initial: "a"}},
+		{i: "(cn=*a)", f: &filter{typ: filterSubstrings, attr: "cn", final: "a"}},
+		{i: "(cn=a*b**c*d)", f: &filter{typ: filterSubstrings, attr: "cn", initial: "a", any: []string{"b", "c"}, final: "d"}},
+		{i: `(cn=a\2ab\28\29\5c)`, f: &filter{typ: filterEquality, attr: "cn", value: `a*b()\`}},
+		{i: `(cn=\2a*)`, f: &filter{typ: filterSubstrings, attr: "cn", initial: "*"}},
+		{i: "(!(uid=a))", f: &filter{typ: filterNot, children: []*filter{{typ: filterEquality, attr: "uid", value: "a"}}}},
+		{i: "(&(uid=a)(|(cn=b)(cn=c)))", f: &filter{typ: filterAnd, children: []*filter{
+			{typ: filterEquality, attr: "uid", value: "a"},
+			{typ: filterOr, children: []*filter{
+				{typ: filterEquality, attr: "cn", value: "b"},
+				{typ: filterEquality, attr: "cn", value: "c"},
+			}},
+		}}},
+	}
+	for _, d := range data {
+		f, err := parseFilter(d.i)
+		require.NoErrorf(t, err, "input: %s", d.i)
+		assert.Equalf(t, d.f, f, "input: %s", d.i)
+	}
+
+	invalids := []string{"", "uid=a", "(uid=a", "(uid=a))", "(=a)", "(uid)", "(&(uid=a)", `(uid=\2)`, `(uid=\zz)`, "(!uid=a)"}
+	for _, i := range invalids {
+		_, err := parseFilter(i)
+		assert.Errorf(t, err, "input: %s", i)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	entry := &ldap.Entry{
+		DN: "uid=alice,ou=users,dc=domain,dc=com",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "uid", Values: []string{"alice"}},
+			{Name: "displayName", Values: []string{"Alice Liddell"}},
+			{Name: "uidNumber", Values: []string{"1001"}},
+			{Name: "objectClass", Values: []string{"inetorgperson"}},
+		},
+	}
+
+	data := []struct {
+		filter string
+		match  bool
+	}{
+		{filter: "(uid=alice)", match: true},
+		{filter: "(UID=Alice)", match: true},
+		{filter: "(uid=bob)", match: false},
+		{filter: "(mail=*)", match: false},
+		{filter: "(displayname=*)", match: true},
+		{filter: "(displayName=alice*)", match: true},
+		{filter: "(displayName=*liddell)", match: true},
+		{filter: "(displayName=*ce l*)", match: true},
+		{filter: "(displayName=a*e*l*l)", match: true},
+		{filter: "(displayName=a*z*l)", match: false},
+		{filter: "(displayName=alice*ice*)", match: false},
+		{filter: "(displayName=*bob*)", match: false},
+		{filter: "(uidNumber>=999)", match: true},
+		{filter: "(uidNumber<=999)", match: false},
+		{filter: "(uid>=bob)", match: false},
+		{filter: "(objectClass=inetOrgPerson)", match: true},
+		{filter: "(!(uid=alice))", match: false},
+		{filter: "(&(uid=alice)(objectClass=inetOrgPerson))", match: true},
+		{filter: "(&(uid=alice)(objectClass=groupOfNames))", match: false},
+		{filter: "(|(uid=bob)(objectClass=inetOrgPerson))", match: true},
+		{filter: "(|(uid=bob)(objectClass=groupOfNames))", match: false},
+		{filter: "(&)", match: true},
+		{filter: "(|)", match: false},
+	}
+	for _, d := range data {
+		f, err := parseFilter(d.filter)
+		require.NoErrorf(t, err, "filter: %s", d.filter)
+		assert.Equalf(t, d.match, f.match(entry), "filter: %s", d.filter)
+	}
+}
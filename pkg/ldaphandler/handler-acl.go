@@ -0,0 +1,219 @@
+package ldaphandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nmcclain/ldap"
+)
+
+// aclRule grant read access to entries under Base that match Filter, limited to Attributes minus DenyAttributes.
+// The rule applies to bound identities listed in DNs, gitea users member of any MemberOf groups,
+// or any gitea users when Users is true
+type aclRule struct {
+	DNs      []string `json:"dns"`
+	MemberOf []string `json:"memberOf"`
+	Users    bool     `json:"users"`
+
+	Base           string   `json:"base"`
+	Filter         string   `json:"filter"`
+	Self           bool     `json:"self"`
+	Attributes     []string `json:"attributes"`
+	DenyAttributes []string `json:"denyAttributes"`
+
+	filter         *filter
+	attributes     map[string]bool
+	denyAttributes map[string]bool
+}
+
+func loadACL(file string) (rules []*aclRule, err error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read acl file failed: %w", err)
+	}
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("decode acl file failed: %w", err)
+	}
+	for i, rule := range rules {
+		if err = rule.init(); err != nil {
+			return nil, fmt.Errorf("invalid acl rule #%d: %w", i, err)
+		}
+	}
+	return
+}
+
+func lowerSet(vs []string, normalize func(string) string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range vs {
+		set[normalize(v)] = true
+	}
+	return set
+}
+
+func (r *aclRule) init() (err error) {
+	if len(r.DNs) == 0 && len(r.MemberOf) == 0 && !r.Users {
+		return fmt.Errorf("rule does not apply to anyone")
+	}
+	for i := range r.DNs {
+		r.DNs[i] = normalizeDN(r.DNs[i])
+	}
+	for i := range r.MemberOf {
+		r.MemberOf[i] = normalizeDN(r.MemberOf[i])
+	}
+	r.Base = normalizeDN(r.Base)
+	if r.Filter != "" {
+		if r.filter, err = parseFilter(r.Filter); err != nil {
+			return
+		}
+	}
+	r.attributes = lowerSet(r.Attributes, strings.ToLower)
+	r.denyAttributes = lowerSet(r.DenyAttributes, strings.ToLower)
+	return
+}
+
+// grants check whether the rule allow reading the entry. dn and boundDN must be normalized
+func (r *aclRule) grants(dn, boundDN string, entry *ldap.Entry) bool {
+	if r.Self && dn != boundDN {
+		return false
+	}
+	if !inScope(dn, r.Base, ldap.ScopeWholeSubtree) {
+		return false
+	}
+	return r.filter == nil || r.filter.match(entry)
+}
+
+func (r *aclRule) allows(attr string) bool {
+	attr = strings.ToLower(attr)
+	return (len(r.attributes) == 0 || r.attributes[attr]) && !r.denyAttributes[attr]
+}
+
+// access is what a bound identity may read. When bases is not nil, only base scope search on those DNs is allowed
+type access struct {
+	boundDN string
+	full    bool
+	rules   []*aclRule
+	bases   map[string]bool
+}
+
+// getAccess return the access of the bound identity, or nil if it may not read anything. Searchers have full access,
+// and so do service accounts unless there are acl rules applied to them. Without applicable acl rules,
+// gitea users may only search their own entry when self search is enabled
+func (h *handler) getAccess(boundDN string) (acc *access, err error) {
+	acc = &access{boundDN: normalizeDN(boundDN)}
+	if h.searchers[strings.ToLower(boundDN)] {
+		acc.full = true
+		return
+	}
+
+	_, isUser := parseChildDN(boundDN, h.userUAttr, h.userParentRDN, h.baseDN)
+	var memberOf map[string]bool
+	for _, rule := range h.acl {
+		applied := rule.Users && isUser
+		for _, dn := range rule.DNs {
+			applied = applied || dn == acc.boundDN
+		}
+		if !applied && isUser && len(rule.MemberOf) > 0 {
+			if memberOf == nil {
+				if memberOf, err = h.getMemberOfDN(boundDN); err != nil {
+					return nil, err
+				}
+			}
+			for _, dn := range rule.MemberOf {
+				applied = applied || memberOf[dn]
+			}
+		}
+		if applied {
+			acc.rules = append(acc.rules, rule)
+		}
+	}
+
+	if len(acc.rules) > 0 {
+		return
+	}
+	if _, ok := h.parseServiceDN(boundDN); ok {
+		acc.full = true
+		return
+	}
+	if h.selfSearch && isUser {
+		acc.full = true
+		acc.bases = map[string]bool{acc.boundDN: true}
+		if !h.selfSearchGroups {
+			return
+		}
+		if memberOf == nil {
+			if memberOf, err = h.getMemberOfDN(boundDN); err != nil {
+				return nil, err
+			}
+		}
+		for dn := range memberOf {
+			acc.bases[dn] = true
+		}
+		return
+	}
+	return nil, nil
+}
+
+// getMemberOfDN return normalized DNs of groups the gitea user identified by dn belong to
+func (h *handler) getMemberOfDN(dn string) (memberOf map[string]bool, err error) {
+	memberOf = map[string]bool{}
+	uname, ok := parseChildDN(dn, h.userUAttr, h.userParentRDN, h.baseDN)
+	if !ok {
+		return
+	}
+	entries, err := h.listCandidates(&filter{typ: filterEquality, attr: strings.ToLower(h.userUAttr), value: uname})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if normalizeDN(entry.DN) != normalizeDN(dn) {
+			continue
+		}
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, "memberOf") {
+				memberOf = lowerSet(attr.Values, normalizeDN)
+			}
+		}
+	}
+	return
+}
+
+// authorize return the entries restricted to what can be read
+func (acc *access) authorize(entries []*ldap.Entry) []*ldap.Entry {
+	if acc.full {
+		return entries
+	}
+	res := make([]*ldap.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if e := acc.restrict(entry); e != nil {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// restrict return a copy of the entry containing only readable attributes, or nil if the entry is not readable
+func (acc *access) restrict(entry *ldap.Entry) *ldap.Entry {
+	dn := normalizeDN(entry.DN)
+	granting := []*aclRule{}
+	for _, rule := range acc.rules {
+		if rule.grants(dn, acc.boundDN, entry) {
+			granting = append(granting, rule)
+		}
+	}
+	if len(granting) == 0 {
+		return nil
+	}
+
+	res := &ldap.Entry{DN: entry.DN}
+	for _, attr := range entry.Attributes {
+		for _, rule := range granting {
+			if rule.allows(attr.Name) {
+				res.Attributes = append(res.Attributes, attr)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,161 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func tACLFile(t *testing.T, content string) (file string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "ldaphandler")
+	require.NoError(t, err)
+	file = filepath.Join(dir, "acl.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestSearchACL(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	services, cleanup := tServiceAccountsFile(t, fmt.Sprintf("jenkins:%s\nmail:%s\n", hash, hash))
+	defer cleanup()
+	acl, cleanup := tACLFile(t, `[
+		{"users": true, "self": true},
+		{"memberOf": ["cn=ops, ou=groups, dc=domain, dc=com"], "base": "ou=users,dc=domain,dc=com", "denyAttributes": ["mail"]},
+		{"dns": ["uid=jenkins,ou=services,dc=domain,dc=com"], "base": "ou=groups,dc=domain,dc=com", "filter": "(cn=ops)", "attributes": ["cn", "member"]}
+	]`)
+	defer cleanup()
+	h, err := New(WithCache(1024*1024, 60), WithServiceAccounts(services), WithACL(acl))
+	require.NoError(t, err)
+
+	entry := func(dn string, attrs map[string][]string) *ldap.Entry {
+		e := &ldap.Entry{DN: dn}
+		for _, name := range []string{"uid", "cn", "mail", "member", "memberOf"} {
+			if values, ok := attrs[name]; ok {
+				e.Attributes = append(e.Attributes, &ldap.EntryAttribute{Name: name, Values: values})
+			}
+		}
+		return e
+	}
+	h.setCachedDirectory(directory{
+		Users: []*ldap.Entry{
+			entry(h.getUserDN("alice"), map[string][]string{"uid": {"alice"}, "mail": {"alice@domain.com"}, "memberOf": {h.getOrgDN("ops")}}),
+			entry(h.getUserDN("bob"), map[string][]string{"uid": {"bob"}, "mail": {"bob@domain.com"}}),
+		},
+		Groups: []*ldap.Entry{
+			entry(h.getOrgDN("ops"), map[string][]string{"cn": {"ops"}, "member": {h.getUserDN("alice")}}),
+			entry(h.getOrgDN("dev"), map[string][]string{"cn": {"dev"}}),
+		},
+	})
+
+	data := []struct {
+		scenario string
+		boundDN  string
+		filter   string
+		dns      []string
+		attrs    []string
+	}{
+		{scenario: "Self", boundDN: h.getUserDN("bob"), filter: "(|(uid=*)(cn=*))",
+			dns: []string{h.getUserDN("bob")}, attrs: []string{"uid", "mail"}},
+		{scenario: "MemberOf", boundDN: h.getUserDN("alice"), filter: "(uid=*)",
+			dns: []string{h.getUserDN("alice"), h.getUserDN("bob")}, attrs: []string{"uid", "mail", "memberOf"}},
+		{scenario: "DeniedAttributeNotSearchable", boundDN: h.getUserDN("alice"), filter: "(mail=bob@domain.com)",
+			dns: []string{}},
+		{scenario: "ServiceRestricted", boundDN: h.getServiceDN("jenkins"), filter: "(|(uid=*)(cn=*))",
+			dns: []string{h.getOrgDN("ops")}, attrs: []string{"cn", "member"}},
+		{scenario: "ServiceWithoutRule", boundDN: h.getServiceDN("mail"), filter: "(|(uid=*)(cn=*))",
+			dns: []string{h.getUserDN("alice"), h.getUserDN("bob"), h.getOrgDN("ops"), h.getOrgDN("dev")}},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: dat.filter}
+			res, err := h.Search(dat.boundDN, req, nil)
+			require.NoError(t, err)
+			dns := []string{}
+			for _, e := range res.Entries {
+				dns = append(dns, e.DN)
+			}
+			assert.Equal(t, dat.dns, dns)
+			if dat.attrs != nil {
+				for _, e := range res.Entries {
+					names := []string{}
+					for _, attr := range e.Attributes {
+						names = append(names, attr.Name)
+					}
+					assert.Subset(t, dat.attrs, names, e.DN)
+				}
+			}
+		})
+	}
+
+	_, err = h.Search("cn=carol,dc=domain,dc=com", ldap.SearchRequest{BaseDN: h.baseDN.String(), Filter: "(uid=*)"}, nil)
+	assert.Error(t, err, "identity without applicable rule should not be able to search")
+}
+
+func TestACLInvalidFile(t *testing.T) {
+	_, err := New(WithACL(filepath.Join("testdata", "notexist")))
+	assert.Error(t, err, "should return error when file not exist")
+
+	for _, content := range []string{"{", `[{"base": "dc=domain,dc=com"}]`, `[{"users": true, "filter": "uid=alice"}]`} {
+		file, cleanup := tACLFile(t, content)
+		_, err = New(WithACL(file))
+		assert.Error(t, err, content)
+		cleanup()
+	}
+}
+
+func TestSearchSelf(t *testing.T) {
+	for _, groups := range []bool{false, true} {
+		h, err := New(WithCache(1024*1024, 60), WithSelfSearch(groups))
+		require.NoError(t, err)
+		h.setCachedDirectory(directory{
+			Users: []*ldap.Entry{
+				{DN: h.getUserDN("alice"), Attributes: []*ldap.EntryAttribute{
+					{Name: "uid", Values: []string{"alice"}},
+					{Name: "memberOf", Values: []string{h.getOrgDN("ops")}},
+				}},
+				{DN: h.getUserDN("bob"), Attributes: []*ldap.EntryAttribute{{Name: "uid", Values: []string{"bob"}}}},
+			},
+			Groups: []*ldap.Entry{
+				{DN: h.getOrgDN("ops"), Attributes: []*ldap.EntryAttribute{{Name: "cn", Values: []string{"ops"}}}},
+			},
+		})
+		search := func(baseDN string, scope int) (*ldap.ServerSearchResult, error) {
+			req := ldap.SearchRequest{BaseDN: baseDN, Scope: scope, Filter: "(|(uid=*)(cn=*))"}
+			res, err := h.Search(h.getUserDN("alice"), req, nil)
+			return &res, err
+		}
+
+		res, err := search("UID=Alice, OU=Users, DC=domain, DC=com", ldap.ScopeBaseObject)
+		require.NoError(t, err)
+		require.Len(t, res.Entries, 1)
+		assert.Equal(t, h.getUserDN("alice"), res.Entries[0].DN)
+		assert.Equal(t, []string{h.getOrgDN("ops")}, res.Entries[0].GetAttributeValues("memberOf"))
+
+		_, err = search(h.getUserDN("alice"), ldap.ScopeWholeSubtree)
+		assert.Error(t, err, "should only allow base scope search")
+		_, err = search(h.getUserDN("bob"), ldap.ScopeBaseObject)
+		assert.Error(t, err, "should not allow searching other user")
+
+		res, err = search(h.getOrgDN("ops"), ldap.ScopeBaseObject)
+		if groups {
+			require.NoError(t, err)
+			require.Len(t, res.Entries, 1)
+			assert.Equal(t, h.getOrgDN("ops"), res.Entries[0].DN)
+		} else {
+			assert.Error(t, err, "should not allow searching groups")
+		}
+	}
+
+	h, err := New()
+	require.NoError(t, err)
+	_, err = h.Search(h.getUserDN("alice"), ldap.SearchRequest{BaseDN: h.getUserDN("alice"), Filter: "(uid=*)"}, nil)
+	assert.Error(t, err, "self search should be disabled by default")
+}
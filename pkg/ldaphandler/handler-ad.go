@@ -0,0 +1,78 @@
+package ldaphandler
+
+import (
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+	"github.com/nmcclain/ldap"
+)
+
+const (
+	profileInetOrgPerson = "inetorgperson"
+	profileAD            = "ad"
+)
+
+// userAccountControl flags, see https://docs.microsoft.com/en-us/troubleshoot/windows-server/identity/useraccountcontrol-manipulate-account-properties
+const (
+	uacAccountDisable = 0x0002
+	uacLockout        = 0x0010
+	uacNormalAccount  = 0x0200
+)
+
+// adGroupType is the groupType of a global security group
+const adGroupType = "-2147483646"
+
+// getADDomain return the DNS domain formed by the dc components of the base DN, e.g. domain.com for dc=domain,dc=com
+func (h *handler) getADDomain() string {
+	dcs := []string{}
+	for _, rdn := range strings.Split(normalizeDN(h.baseDN.String()), ",") {
+		if v := strings.TrimPrefix(rdn, "dc="); v != rdn {
+			dcs = append(dcs, v)
+		}
+	}
+	return strings.Join(dcs, ".")
+}
+
+func (h *handler) getUserPrincipalName(user *models.User) string {
+	return user.Name + "@" + h.getADDomain()
+}
+
+// parseUserPrincipalName return the username of a userPrincipalName created by getUserPrincipalName
+func (h *handler) parseUserPrincipalName(upn string) (name string, ok bool) {
+	i := strings.LastIndexByte(upn, '@')
+	if i <= 0 || !strings.EqualFold(upn[i+1:], h.getADDomain()) {
+		return
+	}
+	return upn[:i], true
+}
+
+func getUserAccountControl(user *models.User) int {
+	uac := uacNormalAccount
+	if !user.IsActive {
+		uac |= uacAccountDisable
+	}
+	if user.ProhibitLogin {
+		uac |= uacLockout
+	}
+	return uac
+}
+
+// newADUserAttributes return Active Directory's user attributes of the user
+func (h *handler) newADUserAttributes(user *models.User) []*ldap.EntryAttribute {
+	return []*ldap.EntryAttribute{
+		{Name: "sAMAccountName", Values: []string{user.Name}},
+		{Name: "userPrincipalName", Values: []string{h.getUserPrincipalName(user)}},
+		{Name: "userAccountControl", Values: []string{strconv.Itoa(getUserAccountControl(user))}},
+		{Name: "objectCategory", Values: []string{"person"}},
+	}
+}
+
+// newADGroupAttributes return Active Directory's group attributes of the group
+func newADGroupAttributes(name string) []*ldap.EntryAttribute {
+	return []*ldap.EntryAttribute{
+		{Name: "sAMAccountName", Values: []string{name}},
+		{Name: "groupType", Values: []string{adGroupType}},
+		{Name: "objectCategory", Values: []string{"group"}},
+	}
+}
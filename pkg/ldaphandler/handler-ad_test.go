@@ -0,0 +1,84 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithProfileInvalid(t *testing.T) {
+	_, err := New(WithProfile("novell"))
+	assert.Error(t, err, "should reject unknown profile")
+}
+
+func TestSearchAD(t *testing.T) {
+	h, err := New(WithProfile("ad"), WithBaseDN("dc=corp,dc=domain,dc=com"))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", FullName: "Alice", Email: "alice@domain.com", IsActive: true}
+	bob := &models.User{ID: 2, Name: "bob", Email: "bob@domain.com", ProhibitLogin: true}
+	org := &models.User{ID: 3, Name: "org", Type: models.UserTypeOrganization}
+	team := &models.Team{ID: 1, OrgID: org.ID, Name: "team"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return([]*models.User{alice, bob}, int64(2), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{org}, int64(1), nil).Times(2)
+	mdl.EXPECT().
+		GetUserByName("alice").
+		Return(alice, nil)
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{team}, nil).Times(3)
+	mdl.EXPECT().
+		SearchTeam(gomock.Any()).
+		Return([]*models.Team{team}, int64(1), nil)
+	h.models = mdl
+
+	attrs := func(entry *ldap.Entry) map[string][]string {
+		m := map[string][]string{}
+		for _, attr := range entry.Attributes {
+			m[attr.Name] = attr.Values
+		}
+		return m
+	}
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree,
+		Filter: "(|(&(objectClass=user)(objectCategory=person))(objectClass=group))"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 4)
+
+	assert.Equal(t, "cn=alice,ou=users,dc=corp,dc=domain,dc=com", res.Entries[0].DN)
+	user := attrs(res.Entries[0])
+	assert.Equal(t, []string{"alice"}, user["cn"])
+	assert.Equal(t, []string{"alice"}, user["sAMAccountName"])
+	assert.Equal(t, []string{"alice@corp.domain.com"}, user["userPrincipalName"])
+	assert.Equal(t, []string{"512"}, user["userAccountControl"])
+	assert.Equal(t, []string{"top", "person", "organizationalperson", "user"}, user["objectClass"])
+	assert.NotContains(t, user, "loginDisabled")
+	assert.Equal(t, []string{"530"}, attrs(res.Entries[1])["userAccountControl"], "inactive and prohibited user should be disabled and locked")
+
+	group := attrs(res.Entries[2])
+	assert.Equal(t, []string{"org"}, group["sAMAccountName"])
+	assert.Equal(t, []string{"-2147483646"}, group["groupType"])
+	assert.Equal(t, []string{"top", "group"}, group["objectClass"])
+	assert.Equal(t, []string{"cn=alice,ou=users,dc=corp,dc=domain,dc=com", "cn=bob,ou=users,dc=corp,dc=domain,dc=com"}, group["member"])
+	assert.NotContains(t, group, "uniqueMember")
+
+	req.Filter = "(userPrincipalName=alice@corp.domain.com)"
+	res, err = h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, "cn=alice,ou=users,dc=corp,dc=domain,dc=com", res.Entries[0].DN)
+}
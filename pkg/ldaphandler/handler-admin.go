@@ -0,0 +1,54 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+	"github.com/nmcclain/ldap"
+)
+
+func (h *handler) getAdminGroupDN() string {
+	return h.getOrgDN(h.adminGroup)
+}
+
+func (h *handler) isAdminGroupDN(dn string) bool {
+	return h.adminGroup != "" && normalizeDN(dn) == normalizeDN(h.getAdminGroupDN())
+}
+
+// checkAdminGroup ensure that no organization has the name of the admin group, since both would have the same DN.
+// With posix attributes, neither may a user, whose private group would have the same DN
+func (h *handler) checkAdminGroup() error {
+	types := []models.UserType{models.UserTypeOrganization}
+	if h.posix {
+		types = append(types, models.UserTypeIndividual)
+	}
+	for _, typ := range types {
+		found, _, err := h.models.SearchUsers(&models.SearchUserOptions{Type: typ, Keyword: h.adminGroup})
+		if err != nil {
+			return fmt.Errorf("search gitea users failed: %w", err)
+		}
+		for _, u := range found {
+			if strings.EqualFold(u.Name, h.adminGroup) {
+				return fmt.Errorf("admin group '%s' collides with an existing group", h.adminGroup)
+			}
+		}
+	}
+	return nil
+}
+
+// newAdminGroupEntry return the synthetic group whose members are gitea site administrators
+func (h *handler) newAdminGroupEntry(members *groupMembers) *ldap.Entry {
+	return h.newGroupEntry(h.groupParentRDN, h.getAdminGroupDN(), h.adminGroup, "Gitea site administrators", 0,
+		members.admins, newOperationalAttributes("admin-group", 0, 0, 0))
+}
+
+// newUserFlagAttributes return gitea's account flags of the user
+func newUserFlagAttributes(user *models.User) []*ldap.EntryAttribute {
+	return []*ldap.EntryAttribute{
+		{Name: "isAdmin", Values: []string{strconv.FormatBool(user.IsAdmin)}},
+		{Name: "isRestricted", Values: []string{strconv.FormatBool(user.IsRestricted)}},
+		{Name: "prohibitLogin", Values: []string{strconv.FormatBool(user.ProhibitLogin)}},
+	}
+}
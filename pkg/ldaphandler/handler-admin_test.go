@@ -0,0 +1,96 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchAdminGroup(t *testing.T) {
+	h, err := New(WithAdminGroup("gitea-admins"), WithUserFlags(), WithCache(16*1024*1024, 60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true, IsAdmin: true}
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true, IsRestricted: true, ProhibitLogin: true}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return([]*models.User{alice, bob}, int64(2), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil)
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{}, nil).Times(2)
+	h.models = mdl
+
+	attrs := func(entry *ldap.Entry) map[string][]string {
+		m := map[string][]string{}
+		for _, attr := range entry.Attributes {
+			m[attr.Name] = attr.Values
+		}
+		return m
+	}
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree,
+		Filter: "(memberOf=" + h.getAdminGroupDN() + ")"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1, "administrators should be listed instead of queried as organization members")
+	assert.Equal(t, h.getUserDN("alice"), res.Entries[0].DN)
+
+	req.Filter = "(|(uid=*)(cn=*))"
+	res, err = h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 3)
+
+	user := attrs(res.Entries[0])
+	assert.Equal(t, []string{"cn=gitea-admins,ou=groups,dc=domain,dc=com"}, user["memberOf"])
+	assert.Equal(t, []string{"true"}, user["isAdmin"])
+	assert.Equal(t, []string{"false"}, user["isRestricted"])
+	assert.NotContains(t, user, "visibility", "only public users are listed")
+	assert.Equal(t, []string{"inetorgperson", "giteauser"}, user["objectClass"])
+
+	user = attrs(res.Entries[1])
+	assert.NotContains(t, user, "memberOf")
+	assert.Equal(t, []string{"false"}, user["isAdmin"])
+	assert.Equal(t, []string{"true"}, user["isRestricted"])
+	assert.Equal(t, []string{"true"}, user["prohibitLogin"])
+
+	assert.Equal(t, h.getAdminGroupDN(), res.Entries[2].DN)
+	assert.Equal(t, []string{h.getUserDN("alice")}, attrs(res.Entries[2])["member"])
+}
+
+func TestAdminGroupCollision(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization, Keyword: "admins"}}).
+		Return([]*models.User{{ID: 3, Name: "Admins", Type: models.UserTypeOrganization}}, int64(1), nil)
+	_, err := New(WithModels(mdl), WithAdminGroup("admins"))
+	assert.Error(t, err, "should refuse admin group named after an organization")
+
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization, Keyword: "admins"}}).
+		Return([]*models.User{{ID: 3, Name: "admins-team", Type: models.UserTypeOrganization}}, int64(1), nil)
+	_, err = New(WithAdminGroup("admins"), WithModels(mdl))
+	assert.NoError(t, err)
+
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization, Keyword: "alice"}}).
+		Return([]*models.User{}, int64(0), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeIndividual, Keyword: "alice"}}).
+		Return([]*models.User{{ID: 1, Name: "alice"}}, int64(1), nil)
+	_, err = New(WithModels(mdl), WithAdminGroup("alice"), WithPosix(1000, 100000, "/home/{{.Name}}", "/bin/sh"))
+	assert.Error(t, err, "should refuse admin group named after the private group of a user")
+}
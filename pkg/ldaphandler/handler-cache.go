@@ -0,0 +1,332 @@
+package ldaphandler
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nmcclain/ldap"
+)
+
+// Cache stores the directory. It is implemented by freecache.Cache
+type Cache interface {
+	Get(key []byte) (value []byte, err error)
+	Set(key, value []byte, expireSeconds int) error
+	Del(key []byte) (affected bool)
+}
+
+// BatchCache is a Cache reading or writing multiple keys in a single round trip, e.g. rediscache.Client.
+// GetMulti return nil values for missing keys, and an error only when the cache can not be read. Errors of
+// Cache.Get can not be told apart from missing keys, so caches that may fail, e.g. remote ones, should implement BatchCache
+type BatchCache interface {
+	Cache
+	GetMulti(keys [][]byte) (values [][]byte, err error)
+	SetMulti(keys, values [][]byte, expireSeconds int) error
+}
+
+var (
+	keyDirectory  = []byte("directory")
+	keyGeneration = []byte("generation")
+)
+
+// cachedIndex lists the DNs of the cached directory. Every entry is cached under its own key, so that
+// the directory is not limited by the maximum size of a cache value and an evicted user can be retrieved alone
+type cachedIndex struct {
+	Users  []string
+	Groups []string
+	Expire int64 // unix time after which the directory is stale, 0 means never
+}
+
+func keyEntry(dn string) []byte {
+	return []byte("entry:" + normalizeDN(dn))
+}
+
+// cacheTTL return how long cached values are kept, including the duration in which they are served stale
+func (h *handler) cacheTTL() int {
+	if h.cacheExpire <= 0 {
+		return 0
+	}
+	return h.cacheExpire + h.cacheStale
+}
+
+func (h *handler) getCached(key []byte, v interface{}) (ok bool) {
+	b, err := h.cache.Get(key)
+	if err != nil {
+		return
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v) == nil
+}
+
+func (h *handler) setCached(key []byte, v interface{}) (ok bool) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return
+	}
+	if err := h.cache.Set(key, buf.Bytes(), h.cacheTTL()); err != nil {
+		h.logger.Warn("caching_failed").WithFields("key", string(key), "error", err)
+		return
+	}
+	return true
+}
+
+// getCachedEntries return the cached entries of dns, nil for missing ones
+func (h *handler) getCachedEntries(dns []string) (entries []*ldap.Entry, err error) {
+	keys := make([][]byte, 0, len(dns))
+	for _, dn := range dns {
+		keys = append(keys, keyEntry(dn))
+	}
+	var values [][]byte
+	if bc, ok := h.cache.(BatchCache); ok {
+		if values, err = bc.GetMulti(keys); err != nil {
+			return
+		}
+	} else {
+		for _, key := range keys {
+			v, _ := h.cache.Get(key)
+			values = append(values, v)
+		}
+	}
+
+	entries = make([]*ldap.Entry, len(dns))
+	for i, v := range values {
+		entry := &ldap.Entry{}
+		if v != nil && gob.NewDecoder(bytes.NewReader(v)).Decode(entry) == nil {
+			entries[i] = entry
+		}
+	}
+	return
+}
+
+func (h *handler) setCachedEntries(entries []*ldap.Entry) (ok bool) {
+	keys, values := make([][]byte, 0, len(entries)), make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+			return
+		}
+		keys, values = append(keys, keyEntry(entry.DN)), append(values, buf.Bytes())
+	}
+
+	if bc, ok := h.cache.(BatchCache); ok {
+		if err := bc.SetMulti(keys, values, h.cacheTTL()); err != nil {
+			h.logger.Warn("caching_failed").WithFields("keys", len(keys), "error", err)
+			return false
+		}
+		return true
+	}
+	for i, key := range keys {
+		if err := h.cache.Set(key, values[i], h.cacheTTL()); err != nil {
+			h.logger.Warn("caching_failed").WithFields("key", string(key), "error", err)
+			return
+		}
+	}
+	return true
+}
+
+// getCachedDirectory return the cached directory, which is stale once the cache expiry has passed.
+// Entries evicted from the cache are retrieved again using userQuery or queryGroup, and removed when they no longer exist.
+// Evicted groups that can not be retrieved alone, as well as failing to read the cache, invalidate the directory
+func (h *handler) getCachedDirectory() (dir directory, stale, ok bool) {
+	if h.cache == nil {
+		return
+	}
+	var idx cachedIndex
+	if !h.getCached(keyDirectory, &idx) {
+		return
+	}
+	cached, err := h.getCachedEntries(append(append([]string{}, idx.Groups...), idx.Users...))
+	if err != nil {
+		h.logger.Warn("read_cached_directory_failed").WithFields("error", err)
+		return
+	}
+
+	byDN, retrieved, evicted := map[string]*ldap.Entry{}, []*ldap.Entry{}, &userQuery{}
+	for i, dn := range idx.Groups {
+		if cached[i] != nil {
+			byDN[normalizeDN(dn)] = cached[i]
+			continue
+		}
+		entry, ok, err := h.queryGroup(dn)
+		if err != nil {
+			h.logger.Warn("query_evicted_group_failed").WithFields("dn", dn, "error", err)
+			return dir, false, false
+		}
+		if !ok {
+			return dir, false, false
+		}
+		if entry != nil {
+			retrieved = append(retrieved, entry)
+			byDN[normalizeDN(entry.DN)] = entry
+		}
+	}
+
+	for i, dn := range idx.Users {
+		if entry := cached[len(idx.Groups)+i]; entry != nil {
+			byDN[normalizeDN(dn)] = entry
+		} else if name, ok := parseChildDN(dn, h.userUAttr, h.userParentRDN, h.baseDN); ok {
+			evicted.names = append(evicted.names, name)
+		}
+	}
+	if len(evicted.names) > 0 {
+		entries, err := h.listQueriedUsers(evicted)
+		if err != nil {
+			h.logger.Warn("list_evicted_users_failed").WithFields("error", err)
+			return dir, false, false
+		}
+		for _, entry := range entries {
+			retrieved = append(retrieved, entry)
+			byDN[normalizeDN(entry.DN)] = entry
+		}
+	}
+	if len(retrieved) > 0 {
+		h.setCachedEntries(retrieved)
+	}
+
+	groups := make([]string, 0, len(idx.Groups))
+	for _, dn := range idx.Groups {
+		if entry, ok := byDN[normalizeDN(dn)]; ok {
+			dir.Groups = append(dir.Groups, entry)
+			groups = append(groups, dn)
+		}
+	}
+	users := make([]string, 0, len(idx.Users))
+	for _, dn := range idx.Users {
+		if entry, ok := byDN[normalizeDN(dn)]; ok {
+			dir.Users = append(dir.Users, entry)
+			users = append(users, dn)
+		}
+	}
+	if len(users) < len(idx.Users) || len(groups) < len(idx.Groups) {
+		idx.Users, idx.Groups = users, groups
+		h.setCached(keyDirectory, idx)
+	}
+
+	stale = idx.Expire > 0 && time.Now().Unix() >= idx.Expire
+	return dir, stale, true
+}
+
+func (h *handler) setCachedDirectory(dir directory) {
+	if h.cache == nil {
+		return
+	}
+	entries := make([]*ldap.Entry, 0, len(dir.Users)+len(dir.Groups))
+	if !h.setCachedEntries(append(append(entries, dir.Users...), dir.Groups...)) {
+		return
+	}
+	idx := cachedIndex{}
+	for _, entry := range dir.Users {
+		idx.Users = append(idx.Users, entry.DN)
+	}
+	for _, entry := range dir.Groups {
+		idx.Groups = append(idx.Groups, entry.DN)
+	}
+	if h.cacheExpire > 0 {
+		idx.Expire = time.Now().Unix() + int64(h.cacheExpire)
+	}
+	h.setCached(keyDirectory, idx)
+}
+
+// getCacheGeneration return the value changed every time the directory is invalidated
+func (h *handler) getCacheGeneration() string {
+	if h.cache == nil {
+		return ""
+	}
+	v, _ := h.cache.Get(keyGeneration)
+	return string(v)
+}
+
+// newCacheGeneration change the cache generation, so that listing that is already running, including by other
+// handlers sharing the cache, does not cache its result
+func (h *handler) newCacheGeneration() {
+	generation := []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+	if err := h.cache.Set(keyGeneration, generation, 0); err != nil {
+		h.logger.Warn("caching_failed").WithFields("key", string(keyGeneration), "error", err)
+	}
+}
+
+// evictEntries remove the cached entries, which getCachedDirectory retrieve again without listing the directory.
+// Cache.Del does not tell missing entries from failures, so entries it did not remove are read again, and
+// the directory is invalidated when they are still cached or can not be read
+func (h *handler) evictEntries(dns ...string) {
+	if h.cache == nil {
+		return
+	}
+	h.newCacheGeneration()
+	var remaining []string
+	for _, dn := range dns {
+		if !h.cache.Del(keyEntry(dn)) {
+			remaining = append(remaining, dn)
+		}
+	}
+	if len(remaining) == 0 {
+		return
+	}
+	entries, err := h.getCachedEntries(remaining)
+	for i := 0; err == nil && i < len(entries); i++ {
+		if entries[i] != nil {
+			err = fmt.Errorf("%s is still cached", remaining[i])
+		}
+	}
+	if err != nil {
+		h.logger.Warn("evict_entries_failed").WithFields("error", err)
+		h.invalidateDirectory()
+	}
+}
+
+// invalidateDirectory remove the cached directory
+func (h *handler) invalidateDirectory() {
+	if h.cache == nil {
+		return
+	}
+	h.newCacheGeneration()
+	h.refreshGroup.Forget(string(keyDirectory))
+	h.cache.Del(keyDirectory)
+	if h.cacheRefresh {
+		h.refreshDirectoryInBackground()
+	}
+}
+
+// refreshDirectory list and cache the directory. Concurrent calls wait for the same listing
+func (h *handler) refreshDirectory() (dir directory, err error) {
+	v, err, _ := h.refreshGroup.Do(string(keyDirectory), func() (interface{}, error) {
+		generation := h.getCacheGeneration()
+		dir, err := h.listDirectory()
+		if err != nil {
+			return dir, err
+		}
+		if h.getCacheGeneration() == generation {
+			h.setCachedDirectory(dir)
+		}
+		return dir, nil
+	})
+	return v.(directory), err
+}
+
+func (h *handler) refreshDirectoryInBackground() {
+	go func() {
+		if _, err := h.refreshDirectory(); err != nil {
+			h.logger.Error("refresh_directory_failed").WithFields("error", err)
+		}
+	}()
+}
+
+func (h *handler) refreshDirectoryPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if h.cache == nil || h.models == nil {
+				continue
+			}
+			if _, err := h.refreshDirectory(); err != nil {
+				h.logger.Error("refresh_directory_failed").WithFields("error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package ldaphandler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	"github.com/coocood/freecache"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedDirectoryEvictedUser(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60))
+	require.NoError(t, err)
+
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil)
+	mdl.EXPECT().
+		GetUserByName("bob").
+		Return(bob, nil)
+	mdl.EXPECT().
+		GetUserByName("carol").
+		Return(nil, models.ErrUserNotExist{Name: "carol"})
+	mdl.EXPECT().
+		GetUserTeams(bob.ID, gomock.Any()).
+		Return([]*models.Team{}, nil)
+	h.models = mdl
+
+	h.setCachedDirectory(directory{
+		Users:  []*ldap.Entry{{DN: h.getUserDN("alice")}, {DN: h.getUserDN("bob")}, {DN: h.getUserDN("carol")}},
+		Groups: []*ldap.Entry{{DN: h.getRepoDN("org", "repo", "")}},
+	})
+	h.cache.Del(keyEntry(h.getUserDN("bob")))
+	h.cache.Del(keyEntry(h.getUserDN("carol")))
+
+	dir, stale, ok := h.getCachedDirectory()
+	require.True(t, ok)
+	assert.False(t, stale)
+	require.Len(t, dir.Users, 2, "evicted user that no longer exists should be removed")
+	assert.Equal(t, h.getUserDN("alice"), dir.Users[0].DN)
+	assert.Equal(t, h.getUserDN("bob"), dir.Users[1].DN)
+	assert.NotEmpty(t, dir.Users[1].Attributes, "evicted user should be retrieved again")
+	_, _, ok = h.getCachedDirectory()
+	assert.True(t, ok, "retrieved user should be cached")
+
+	h.cache.Del(keyEntry(h.getRepoDN("org", "repo", "")))
+	_, _, ok = h.getCachedDirectory()
+	assert.False(t, ok, "evicted group that can not be retrieved alone should invalidate the directory")
+}
+
+func TestCachedDirectoryEvictedGroup(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true}
+	carol := &models.User{ID: 3, Name: "carol", IsActive: true, Visibility: structs.VisibleTypePrivate}
+	org := &models.User{ID: 2, Name: "org", Type: models.UserTypeOrganization}
+	owners := &models.Team{ID: 1, OrgID: org.ID, Name: "Owners"}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().GetUserByName("org").Return(org, nil).Times(2)
+	mdl.EXPECT().GetUserByName("other").Return(nil, models.ErrUserNotExist{Name: "other"})
+	mdl.EXPECT().GetTeam(org.ID, "owners").Return(owners, nil)
+	mdl.EXPECT().
+		SearchTeam(reflectEq{&models.SearchTeamOptions{OrgID: org.ID, ListOptions: models.ListOptions{PageSize: -1}}}).
+		Return([]*models.Team{owners}, int64(1), nil)
+	mdl.EXPECT().GetTeamMembers(owners.ID).Return([]*models.User{alice, carol}, nil).Times(2)
+	h.models = mdl
+
+	h.setCachedDirectory(directory{
+		Users:  []*ldap.Entry{{DN: h.getUserDN("alice")}},
+		Groups: []*ldap.Entry{{DN: h.getOrgDN("org")}, {DN: h.getTeamDN("org", "Owners")}, {DN: h.getOrgDN("other")}},
+	})
+	h.evictEntries(h.getOrgDN("org"), h.getTeamDN("org", "Owners"), h.getOrgDN("other"))
+
+	dir, _, ok := h.getCachedDirectory()
+	require.True(t, ok)
+	require.Len(t, dir.Groups, 2, "evicted group that no longer exists should be removed")
+	for _, entry := range dir.Groups {
+		assert.Equal(t, []string{h.getUserDN("alice")}, entry.GetAttributeValues("member"), "only listed users should be members")
+	}
+	assert.Equal(t, "org[Owners]", dir.Groups[1].GetAttributeValue("cn"))
+	_, _, ok = h.getCachedDirectory()
+	assert.True(t, ok, "retrieved groups should be cached")
+}
+
+func TestCachedDirectoryStale(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60), WithCacheStale(60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return([]*models.User{alice}, int64(1), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil)
+	mdl.EXPECT().
+		GetUserTeams(alice.ID, gomock.Any()).
+		Return([]*models.Team{}, nil)
+	h.models = mdl
+
+	stale := &ldap.Entry{DN: h.getUserDN("alice"), Attributes: []*ldap.EntryAttribute{{Name: "uid", Values: []string{"alice"}}}}
+	h.setCachedDirectory(directory{Users: []*ldap.Entry{stale}})
+	h.setCached(keyDirectory, cachedIndex{Users: []string{stale.DN}, Expire: time.Now().Unix() - 1})
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: "(uid=*)"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, stale.Attributes, res.Entries[0].Attributes, "stale directory should be served")
+
+	assert.Eventually(t, func() bool {
+		dir, stale, ok := h.getCachedDirectory()
+		return ok && !stale && len(dir.Users) == 1 && len(dir.Users[0].Attributes) > 1
+	}, time.Second, 10*time.Millisecond, "directory should be refreshed in the background")
+}
+
+func TestRefreshDirectoryConcurrently(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60))
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		DoAndReturn(func(opts *models.SearchUserOptions) ([]*models.User, int64, error) {
+			<-release
+			return []*models.User{}, int64(0), nil
+		})
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil)
+	h.models = mdl
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := h.refreshDirectory()
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestSharedCacheInvalidation(t *testing.T) {
+	c := freecache.NewCache(1024 * 1024)
+	h1, err := New(WithCacheBackend(c, 60))
+	require.NoError(t, err)
+	h2, err := New(WithCacheBackend(c, 60))
+	require.NoError(t, err)
+
+	h1.setCachedDirectory(directory{Users: []*ldap.Entry{{DN: h1.getUserDN("alice")}}})
+	dir, _, ok := h2.getCachedDirectory()
+	require.True(t, ok, "directory should be shared")
+	assert.Len(t, dir.Users, 1)
+
+	generation := h1.getCacheGeneration()
+	h2.invalidateDirectory()
+	_, _, ok = h1.getCachedDirectory()
+	assert.False(t, ok, "invalidation should be shared")
+	assert.NotEqual(t, generation, h1.getCacheGeneration(), "running listing should not cache its result")
+}
+
+// tBatchCache is a BatchCache counting its round trips, whose reads fail when failing is set, and whose
+// next failingDels deletes fail
+type tBatchCache struct {
+	*freecache.Cache
+	failing     bool
+	failingDels int
+	roundTrips  int
+}
+
+func (c *tBatchCache) Del(key []byte) (affected bool) {
+	if c.failingDels > 0 {
+		c.failingDels--
+		return false
+	}
+	return c.Cache.Del(key)
+}
+
+func (c *tBatchCache) GetMulti(keys [][]byte) (values [][]byte, err error) {
+	c.roundTrips++
+	if c.failing {
+		return nil, errors.New("connection refused")
+	}
+	for _, key := range keys {
+		v, _ := c.Get(key)
+		values = append(values, v)
+	}
+	return
+}
+
+func (c *tBatchCache) SetMulti(keys, values [][]byte, expireSeconds int) (err error) {
+	c.roundTrips++
+	for i, key := range keys {
+		if err = c.Set(key, values[i], expireSeconds); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func TestBatchCache(t *testing.T) {
+	c := &tBatchCache{Cache: freecache.NewCache(1024 * 1024)}
+	h, err := New(WithCacheBackend(c, 60))
+	require.NoError(t, err)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	h.models = mock.NewMockModels(ctrl)
+
+	h.setCachedDirectory(directory{
+		Users:  []*ldap.Entry{{DN: h.getUserDN("alice")}, {DN: h.getUserDN("bob")}},
+		Groups: []*ldap.Entry{{DN: h.getOrgDN("org")}},
+	})
+	assert.Equal(t, 1, c.roundTrips, "entries should be written at once")
+	dir, _, ok := h.getCachedDirectory()
+	require.True(t, ok)
+	assert.Len(t, dir.Users, 2)
+	assert.Equal(t, 2, c.roundTrips, "entries should be read at once")
+
+	c.failing = true
+	_, _, ok = h.getCachedDirectory()
+	assert.False(t, ok, "failing cache should miss the whole directory without retrieving entries")
+	c.failing = false
+	_, _, ok = h.getCachedDirectory()
+	assert.True(t, ok, "failing read should not evict entries")
+}
+
+func TestEvictEntriesFailing(t *testing.T) {
+	c := &tBatchCache{Cache: freecache.NewCache(1024 * 1024)}
+	h, err := New(WithCacheBackend(c, 60))
+	require.NoError(t, err)
+	dir := directory{
+		Users:  []*ldap.Entry{{DN: h.getUserDN("alice")}},
+		Groups: []*ldap.Entry{{DN: h.getOrgDN("org")}},
+	}
+
+	h.setCachedDirectory(dir)
+	h.evictEntries(h.getUserDN("alice"), h.getUserDN("bob"))
+	assert.True(t, h.getCached(keyDirectory, &cachedIndex{}), "missing entries should not invalidate the directory")
+	assert.False(t, h.getCached(keyEntry(h.getUserDN("alice")), &ldap.Entry{}))
+
+	h.setCachedDirectory(dir)
+	c.failingDels = 1
+	h.evictEntries(h.getUserDN("alice"))
+	assert.False(t, h.getCached(keyDirectory, &cachedIndex{}), "entries still cached should invalidate the directory")
+
+	h.setCachedDirectory(dir)
+	c.failing = true
+	h.evictEntries(h.getUserDN("bob"))
+	c.failing = false
+	assert.False(t, h.getCached(keyDirectory, &cachedIndex{}), "unreadable entries should invalidate the directory")
+}
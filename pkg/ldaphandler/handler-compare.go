@@ -0,0 +1,73 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nmcclain/ldap"
+)
+
+// dnAttributes are attributes whose values are compared as distinguished names
+var dnAttributes = map[string]bool{
+	"member":       true,
+	"uniquemember": true,
+	"memberof":     true,
+}
+
+// Compare evaluate the attribute value assertion against the entry identified by dn,
+// honoring the same access rules as search
+func (h *handler) Compare(boundDN, dn, attribute, value string, conn net.Conn) (ldap.LDAPResultCode, error) {
+	boundDN = h.canonicalDN(boundDN)
+	req := ldap.SearchRequest{BaseDN: dn, Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)"}
+	acc, err := h.checkSearchPermission(boundDN, req)
+	if err != nil {
+		h.logger.Error("insufficient_access_right").WithFields("error", err)
+		return ldap.LDAPResultInsufficientAccessRights, err
+	}
+
+	f := &filter{typ: filterPresent, attr: "objectclass"}
+	if uname, ok := parseChildDN(dn, h.userUAttr, h.userParentRDN, h.baseDN); ok {
+		f = &filter{typ: filterEquality, attr: strings.ToLower(h.userUAttr), value: uname}
+	}
+	candidates, err := h.listCandidates(f)
+	if err != nil {
+		h.logger.Error("list_directory_failed").WithFields("error", err)
+		return ldap.LDAPResultOperationsError, err
+	}
+
+	var entry *ldap.Entry
+	for _, e := range acc.authorize(candidates) {
+		if normalizeDN(e.DN) == normalizeDN(dn) {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return ldap.LDAPResultNoSuchObject, fmt.Errorf("Compare Error: entry %s does not exist", dn)
+	}
+
+	found := false
+	for _, attr := range entry.Attributes {
+		if !strings.EqualFold(attr.Name, attribute) {
+			continue
+		}
+		found = true
+		for _, v := range attr.Values {
+			if compareEqual(attribute, v, value) {
+				return ldap.LDAPResultCompareTrue, nil
+			}
+		}
+	}
+	if !found {
+		return ldap.LDAPResultNoSuchAttribute, fmt.Errorf("Compare Error: entry %s has no attribute %s", dn, attribute)
+	}
+	return ldap.LDAPResultCompareFalse, nil
+}
+
+func compareEqual(attribute, a, b string) bool {
+	if dnAttributes[strings.ToLower(attribute)] {
+		return normalizeDN(a) == normalizeDN(b)
+	}
+	return strings.EqualFold(a, b)
+}
@@ -0,0 +1,65 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	acl, cleanup := tACLFile(t, `[{"users": true, "self": true, "denyAttributes": ["mail"]}]`)
+	defer cleanup()
+	h, err := New(WithCache(1024*1024, 60), WithACL(acl))
+	require.NoError(t, err)
+	h.setCachedDirectory(directory{
+		Users: []*ldap.Entry{
+			{DN: h.getUserDN("alice"), Attributes: []*ldap.EntryAttribute{
+				{Name: "uid", Values: []string{"alice"}},
+				{Name: "mail", Values: []string{"alice@domain.com"}},
+				{Name: "memberOf", Values: []string{h.getOrgDN("org")}},
+			}},
+		},
+		Groups: []*ldap.Entry{
+			{DN: h.getOrgDN("org"), Attributes: []*ldap.EntryAttribute{
+				{Name: "cn", Values: []string{"org"}},
+				{Name: "member", Values: []string{h.getUserDN("alice")}},
+			}},
+		},
+	})
+
+	data := []struct {
+		scenario  string
+		boundDN   string
+		dn        string
+		attribute string
+		value     string
+		code      ldap.LDAPResultCode
+	}{
+		{scenario: "MemberTrue", boundDN: h.getUserDN("admin"), dn: h.getOrgDN("org"),
+			attribute: "member", value: "UID=Alice, OU=Users, DC=domain, DC=com", code: ldap.LDAPResultCompareTrue},
+		{scenario: "MemberFalse", boundDN: h.getUserDN("admin"), dn: h.getOrgDN("org"),
+			attribute: "member", value: h.getUserDN("bob"), code: ldap.LDAPResultCompareFalse},
+		{scenario: "MemberOfTrue", boundDN: h.getUserDN("admin"), dn: h.getUserDN("alice"),
+			attribute: "memberOf", value: h.getOrgDN("org"), code: ldap.LDAPResultCompareTrue},
+		{scenario: "NoSuchAttribute", boundDN: h.getUserDN("admin"), dn: h.getOrgDN("org"),
+			attribute: "description", value: "org", code: ldap.LDAPResultNoSuchAttribute},
+		{scenario: "NoSuchObject", boundDN: h.getUserDN("admin"), dn: h.getOrgDN("unknown"),
+			attribute: "cn", value: "unknown", code: ldap.LDAPResultNoSuchObject},
+		{scenario: "Self", boundDN: h.getUserDN("alice"), dn: h.getUserDN("alice"),
+			attribute: "memberOf", value: h.getOrgDN("org"), code: ldap.LDAPResultCompareTrue},
+		{scenario: "DeniedAttribute", boundDN: h.getUserDN("alice"), dn: h.getUserDN("alice"),
+			attribute: "mail", value: "alice@domain.com", code: ldap.LDAPResultNoSuchAttribute},
+		{scenario: "NotReadable", boundDN: h.getUserDN("alice"), dn: h.getOrgDN("org"),
+			attribute: "member", value: h.getUserDN("alice"), code: ldap.LDAPResultNoSuchObject},
+		{scenario: "Anonymous", boundDN: "", dn: h.getOrgDN("org"),
+			attribute: "member", value: h.getUserDN("alice"), code: ldap.LDAPResultInsufficientAccessRights},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			code, _ := h.Compare(dat.boundDN, dat.dn, dat.attribute, dat.value, nil)
+			assert.Equal(t, dat.code, code)
+		})
+	}
+}
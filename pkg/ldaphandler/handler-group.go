@@ -0,0 +1,205 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"strconv"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	"github.com/nmcclain/ldap"
+)
+
+type groupMember struct {
+	dn  string
+	uid string
+}
+
+// groupMembers collects members of organizations and teams, in the order the users are listed
+type groupMembers struct {
+	byOrg  map[int64][]groupMember
+	byTeam map[int64][]groupMember
+	byUser map[int64]groupMember
+	admins []groupMember
+
+	inOrg map[int64]map[string]bool
+}
+
+func newGroupMembers() *groupMembers {
+	return &groupMembers{
+		byOrg:  map[int64][]groupMember{},
+		byTeam: map[int64][]groupMember{},
+		byUser: map[int64]groupMember{},
+		inOrg:  map[int64]map[string]bool{},
+	}
+}
+
+func (m *groupMembers) add(user *models.User, userDN string, teams []*models.Team) {
+	member := groupMember{dn: userDN, uid: user.Name}
+	m.byUser[user.ID] = member
+	if user.IsAdmin {
+		m.admins = append(m.admins, member)
+	}
+	for _, team := range teams {
+		m.byTeam[team.ID] = append(m.byTeam[team.ID], member)
+
+		if m.inOrg[team.OrgID] == nil {
+			m.inOrg[team.OrgID] = map[string]bool{}
+		}
+		if !m.inOrg[team.OrgID][userDN] {
+			m.byOrg[team.OrgID] = append(m.byOrg[team.OrgID], member)
+			m.inOrg[team.OrgID][userDN] = true
+		}
+	}
+}
+
+// newGroupEntry return the entry of a group under parent. posixGroup attributes are omitted when gidNumber is 0
+func (h *handler) newGroupEntry(parent names, dn, name, description string, gidNumber int64, members []groupMember,
+	operational []*ldap.EntryAttribute) *ldap.Entry {
+	dns, uids := []string{}, []string{}
+	for _, member := range members {
+		dns, uids = append(dns, member.dn), append(uids, member.uid)
+	}
+
+	attrs := []*ldap.EntryAttribute{}
+	attrs = append(attrs, &ldap.EntryAttribute{Name: h.groupUAttr, Values: []string{name}})
+	if description != "" {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "description", Values: []string{description}})
+	}
+	objectClass := []string{"groupofnames", "groupofuniquenames"}
+	if h.profile == profileAD {
+		attrs = append(attrs, newADGroupAttributes(name)...)
+		objectClass = []string{"top", "group"}
+	}
+	if len(members) > 0 {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "member", Values: dns})
+		if h.profile != profileAD {
+			attrs = append(attrs, &ldap.EntryAttribute{Name: "uniqueMember", Values: dns})
+		}
+	}
+	if h.posix && gidNumber != 0 {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "gidNumber", Values: []string{strconv.FormatInt(gidNumber, 10)}})
+		if len(uids) > 0 {
+			attrs = append(attrs, &ldap.EntryAttribute{Name: "memberUid", Values: uids})
+		}
+		objectClass = append(objectClass, "posixgroup")
+	}
+	if h.mappings != nil {
+		attrs = h.applyMapping(&h.mappings.Groups, attrs, nil)
+	}
+	attrs = append(attrs, &ldap.EntryAttribute{Name: "objectClass", Values: objectClass})
+	attrs = append(attrs, parent.Attributes()...)
+	attrs = append(attrs, h.baseDN.Attributes()...)
+	attrs = append(attrs, operational...)
+
+	return &ldap.Entry{DN: dn, Attributes: attrs}
+}
+
+func (h *handler) newOrgEntry(org *models.User, members []groupMember) *ldap.Entry {
+	return h.newGroupEntry(h.groupParentRDN,
+		h.getOrgDN(org.Name), org.Name, org.Description, h.getOrgGIDNumber(org), members,
+		newOperationalAttributes("user", org.ID, org.CreatedUnix, org.UpdatedUnix),
+	)
+}
+
+func (h *handler) newTeamEntry(org *models.User, team *models.Team, members []groupMember) *ldap.Entry {
+	return h.newGroupEntry(h.groupParentRDN,
+		h.getTeamDN(org.Name, team.Name), fmt.Sprintf("%s[%s]", org.Name, team.Name), team.Description,
+		h.getTeamGIDNumber(team), members,
+		newOperationalAttributes("team", team.ID, 0, 0),
+	)
+}
+
+// listGroups return one entry for every organization and every team of those organizations
+func (h *handler) listGroups(orgs []*models.User, members *groupMembers) (entries []*ldap.Entry, err error) {
+	for _, org := range orgs {
+		teams, _, err := h.models.SearchTeam(&models.SearchTeamOptions{OrgID: org.ID, ListOptions: models.ListOptions{PageSize: -1}})
+		if err != nil {
+			return nil, fmt.Errorf("search organization's teams failed: %w", err)
+		}
+
+		entries = append(entries, h.newOrgEntry(org, members.byOrg[org.ID]))
+		for _, team := range teams {
+			entries = append(entries, h.newTeamEntry(org, team, members.byTeam[team.ID]))
+		}
+	}
+	return
+}
+
+// listTeamMembers return the listed members of the team
+func (h *handler) listTeamMembers(team *models.Team) (members []groupMember, err error) {
+	users, err := h.models.GetTeamMembers(team.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get team's members failed: %w", err)
+	}
+	for _, user := range users {
+		if isListed(user) {
+			members = append(members, groupMember{dn: h.getUserDN(user.Name), uid: user.Name})
+		}
+	}
+	return
+}
+
+// queryGroup retrieve the entry of an organization, a team, or a private group by its DN, the same way as listDirectory.
+// Other groups, e.g. the admin group or repository groups, can only be retrieved by listing the directory, which
+// is reported by ok being false. The entry is nil when the group no longer exists
+func (h *handler) queryGroup(dn string) (entry *ldap.Entry, ok bool, err error) {
+	if h.isAdminGroupDN(dn) {
+		return nil, false, nil
+	}
+	name, teamName, ok := h.parseGroupDN(dn)
+	if !ok {
+		return nil, false, nil
+	}
+	owner, err := h.models.GetUserByName(name)
+	if models.IsErrUserNotExist(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("get gitea user by name failed: %w", err)
+	}
+
+	switch {
+	case owner.Type == models.UserTypeIndividual:
+		if !h.posix || teamName != "" || !isListed(owner) {
+			return nil, true, nil
+		}
+		return h.newPrivateGroupEntry(owner, groupMember{dn: h.getUserDN(owner.Name), uid: owner.Name}), true, nil
+
+	case owner.Type != models.UserTypeOrganization || owner.Visibility != structs.VisibleTypePublic:
+		return nil, true, nil
+
+	case teamName != "":
+		team, err := h.models.GetTeam(owner.ID, teamName)
+		if models.IsErrTeamNotExist(err) {
+			return nil, true, nil
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("get team failed: %w", err)
+		}
+		members, err := h.listTeamMembers(team)
+		if err != nil {
+			return nil, true, err
+		}
+		return h.newTeamEntry(owner, team, members), true, nil
+	}
+
+	teams, _, err := h.models.SearchTeam(&models.SearchTeamOptions{OrgID: owner.ID, ListOptions: models.ListOptions{PageSize: -1}})
+	if err != nil {
+		return nil, true, fmt.Errorf("search organization's teams failed: %w", err)
+	}
+	var members []groupMember
+	seen := map[string]bool{}
+	for _, team := range teams {
+		tm, err := h.listTeamMembers(team)
+		if err != nil {
+			return nil, true, err
+		}
+		for _, member := range tm {
+			if !seen[member.dn] {
+				members = append(members, member)
+				seen[member.dn] = true
+			}
+		}
+	}
+	return h.newOrgEntry(owner, members), true, nil
+}
@@ -0,0 +1,170 @@
+package ldaphandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/nmcclain/ldap"
+)
+
+// attributeMapping change the attributes of entries. Templates add attributes computed from gitea user,
+// Copy duplicate attributes under other names, and Rename change attribute names, in that order
+type attributeMapping struct {
+	Templates map[string]string   `json:"templates"`
+	Copy      map[string][]string `json:"copy"`
+	Rename    map[string]string   `json:"rename"`
+
+	templates []*template.Template
+}
+
+type attributeMappings struct {
+	Users  attributeMapping `json:"users"`
+	Groups attributeMapping `json:"groups"`
+}
+
+var mappingFuncs = template.FuncMap{
+	"firstName":       firstName,
+	"lastName":        lastName,
+	"generalizedTime": generalizedTime,
+}
+
+// firstName return every words of the full name except the last one
+func firstName(fullName string) string {
+	words := strings.Fields(fullName)
+	if len(words) < 2 {
+		return ""
+	}
+	return strings.Join(words[:len(words)-1], " ")
+}
+
+// lastName return the last word of the full name
+func lastName(fullName string) string {
+	words := strings.Fields(fullName)
+	if len(words) == 0 {
+		return ""
+	}
+	return words[len(words)-1]
+}
+
+// generalizedTime format the timestamp using LDAP GeneralizedTime syntax
+func generalizedTime(ts timeutil.TimeStamp) string {
+	return time.Unix(int64(ts), 0).UTC().Format("20060102150405Z")
+}
+
+func loadAttributeMappings(file string) (m *attributeMappings, err error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read attribute mapping file failed: %w", err)
+	}
+	m = &attributeMappings{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("decode attribute mapping file failed: %w", err)
+	}
+	if len(m.Groups.Templates) > 0 {
+		return nil, fmt.Errorf("templates are only supported for users")
+	}
+
+	names := make([]string, 0, len(m.Users.Templates))
+	for name := range m.Users.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tmpl, err := template.New(name).Funcs(mappingFuncs).Parse(m.Users.Templates[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		if _, err = executeTemplate(tmpl, &models.User{}); err != nil {
+			return nil, fmt.Errorf("invalid %s template: %w", name, err)
+		}
+		m.Users.templates = append(m.Users.templates, tmpl)
+	}
+	return
+}
+
+// validate ensure the mapping does not rename the attribute used as RDN
+func (m *attributeMapping) validate(rdnAttr string) error {
+	for from := range m.Rename {
+		if strings.EqualFold(from, rdnAttr) {
+			return fmt.Errorf("attribute %s is used as RDN and can not be renamed", rdnAttr)
+		}
+	}
+	return nil
+}
+
+// touches check whether the mapping add, copy, or rename the attribute, in which case its values may not be
+// the ones of the gitea field it is usually derived from
+func (m *attributeMapping) touches(attr string) bool {
+	for name := range m.Templates {
+		if strings.EqualFold(name, attr) {
+			return true
+		}
+	}
+	for from, to := range m.Copy {
+		if strings.EqualFold(from, attr) {
+			return true
+		}
+		for _, name := range to {
+			if strings.EqualFold(name, attr) {
+				return true
+			}
+		}
+	}
+	for from, to := range m.Rename {
+		if strings.EqualFold(from, attr) || strings.EqualFold(to, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMapping return the attributes changed by the mapping. user may be nil when mapping a group
+func (h *handler) applyMapping(m *attributeMapping, attrs []*ldap.EntryAttribute, user *models.User) []*ldap.EntryAttribute {
+	if user != nil {
+		for _, tmpl := range m.templates {
+			v, err := executeTemplate(tmpl, user)
+			if err != nil {
+				h.logger.Warn("execute_template_failed").WithFields("attribute", tmpl.Name(), "user", user.Name, "error", err)
+				continue
+			}
+			if v != "" {
+				attrs = append(attrs, &ldap.EntryAttribute{Name: tmpl.Name(), Values: []string{v}})
+			}
+		}
+	}
+
+	copies := []*ldap.EntryAttribute{}
+	for _, attr := range attrs {
+		for from, to := range m.Copy {
+			if !strings.EqualFold(attr.Name, from) {
+				continue
+			}
+			for _, name := range to {
+				copies = append(copies, &ldap.EntryAttribute{Name: name, Values: attr.Values})
+			}
+		}
+	}
+	attrs = append(attrs, copies...)
+
+	if len(m.Rename) == 0 {
+		return attrs
+	}
+	res := make([]*ldap.EntryAttribute, 0, len(attrs))
+	for _, attr := range attrs {
+		for from, to := range m.Rename {
+			if strings.EqualFold(attr.Name, from) {
+				attr = &ldap.EntryAttribute{Name: to, Values: attr.Values}
+				break
+			}
+		}
+		res = append(res, attr)
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+package ldaphandler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tMappingFile(t *testing.T, content string) (file string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "ldaphandler")
+	require.NoError(t, err)
+	file = filepath.Join(dir, "mapping.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestAttributeMapping(t *testing.T) {
+	file, cleanup := tMappingFile(t, `{
+		"users": {
+			"templates": {
+				"givenName": "{{firstName .FullName}}",
+				"sn": "{{lastName .FullName}}",
+				"labeledURI": "{{.Website}}",
+				"whenCreated": "{{generalizedTime .CreatedUnix}}",
+				"employeeType": "{{if .IsAdmin}}admin{{else}}user{{end}}"
+			},
+			"copy": {"displayName": ["cn", "gecos"]},
+			"rename": {"mail": "email"}
+		},
+		"groups": {"rename": {"member": "roleOccupant"}}
+	}`)
+	defer cleanup()
+	h, err := New(WithUserUniqueAttribute("sAMAccountName"), WithGroupUniqueAttribute("name"), WithAttributeMapping(file))
+	require.NoError(t, err)
+
+	user := &models.User{ID: 1, Name: "alice", FullName: "Alice Mary Liddell", Email: "alice@domain.com",
+		IsActive: true, IsAdmin: true, CreatedUnix: timeutil.TimeStamp(1577836800)}
+	entry := h.newUserEntry(user, nil, nil, nil)
+	assert.Equal(t, "sAMAccountName=alice,ou=users,dc=domain,dc=com", entry.DN)
+
+	attrs := map[string][]string{}
+	for _, attr := range entry.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+	assert.Equal(t, []string{"alice"}, attrs["sAMAccountName"])
+	assert.Equal(t, []string{"Alice Mary"}, attrs["givenName"])
+	assert.Equal(t, []string{"Liddell"}, attrs["sn"])
+	assert.NotContains(t, attrs, "labeledURI", "empty value should be omitted")
+	assert.Equal(t, []string{"20200101000000Z"}, attrs["whenCreated"])
+	assert.Equal(t, []string{"admin"}, attrs["employeeType"])
+	assert.Equal(t, []string{"Alice Mary Liddell"}, attrs["cn"])
+	assert.Equal(t, []string{"Alice Mary Liddell"}, attrs["gecos"])
+	assert.Equal(t, []string{"Alice Mary Liddell"}, attrs["displayName"])
+	assert.Equal(t, []string{"alice@domain.com"}, attrs["email"])
+	assert.NotContains(t, attrs, "mail")
+
+	group := h.newGroupEntry(h.groupParentRDN, h.getOrgDN("org"), "org", "", 0, []groupMember{{dn: entry.DN, uid: "alice"}}, nil)
+	assert.Equal(t, "name=org,ou=groups,dc=domain,dc=com", group.DN)
+	assert.Equal(t, []string{entry.DN}, group.GetAttributeValues("roleOccupant"))
+	assert.Empty(t, group.GetAttributeValues("member"))
+	org, _, ok := h.parseGroupDN(group.DN)
+	assert.True(t, ok)
+	assert.Equal(t, "org", org)
+}
+
+func TestAttributeMappingInvalid(t *testing.T) {
+	for _, content := range []string{
+		"{",
+		`{"users": {"templates": {"sn": "{{lastName .FullName"}}}`,
+		`{"users": {"templates": {"sn": "{{.Unknown}}"}}}`,
+		`{"groups": {"templates": {"sn": "{{.Name}}"}}}`,
+		`{"users": {"rename": {"UID": "login"}}}`,
+	} {
+		file, cleanup := tMappingFile(t, content)
+		_, err := New(WithAttributeMapping(file))
+		assert.Error(t, err, content)
+		cleanup()
+	}
+}
@@ -0,0 +1,60 @@
+package ldaphandler
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/nmcclain/ldap"
+)
+
+// entryUUIDNamespace is the namespace of name based entryUUID generated from gitea IDs
+var entryUUIDNamespace = [16]byte{0x6b, 0x1e, 0x2f, 0x5c, 0x8d, 0x43, 0x4a, 0x61, 0x9c, 0x0e, 0x3a, 0x7f, 0x52, 0xd4, 0x18, 0xb9}
+
+// operationalAttributes are only returned when requested explicitly or using '+'
+var operationalAttributes = map[string]bool{
+	"entryuuid":       true,
+	"createtimestamp": true,
+	"modifytimestamp": true,
+
+	// root DSE and subschema
+	"namingcontexts":       true,
+	"subschemasubentry":    true,
+	"supportedldapversion": true,
+	"supportedcontrol":     true,
+	"supportedextension":   true,
+	"vendorname":           true,
+	"attributetypes":       true,
+	"objectclasses":        true,
+}
+
+// newEntryUUID return RFC 4122 version 5 UUID of the gitea object, which is stable across renames.
+// kind distinguishes objects stored in different tables, e.g. user and team
+func newEntryUUID(kind string, id int64) string {
+	h := sha1.New()
+	h.Write(entryUUIDNamespace[:])
+	h.Write([]byte(kind + ":" + strconv.FormatInt(id, 10)))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// newOperationalAttributes return entryUUID, createTimestamp, and modifyTimestamp of the gitea object.
+// Zero timestamps are omitted
+func newOperationalAttributes(kind string, id int64, created, updated timeutil.TimeStamp) []*ldap.EntryAttribute {
+	attrs := []*ldap.EntryAttribute{{Name: "entryUUID", Values: []string{newEntryUUID(kind, id)}}}
+	if !created.IsZero() {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "createTimestamp", Values: []string{generalizedTime(created)}})
+	}
+	if !updated.IsZero() {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "modifyTimestamp", Values: []string{generalizedTime(updated)}})
+	}
+	return attrs
+}
+
+func isOperationalAttribute(name string) bool {
+	return operationalAttributes[strings.ToLower(name)]
+}
@@ -0,0 +1,61 @@
+package ldaphandler
+
+import (
+	"regexp"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/timeutil"
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntryUUID(t *testing.T) {
+	id := newEntryUUID("user", 1)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), id)
+	assert.Equal(t, id, newEntryUUID("user", 1), "should be deterministic")
+	assert.NotEqual(t, id, newEntryUUID("user", 2))
+	assert.NotEqual(t, id, newEntryUUID("team", 1))
+}
+
+func TestSearchOperationalAttributes(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true,
+		CreatedUnix: timeutil.TimeStamp(1577836800), UpdatedUnix: timeutil.TimeStamp(1593561600)}
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true,
+		CreatedUnix: timeutil.TimeStamp(1590969600), UpdatedUnix: timeutil.TimeStamp(1590969600)}
+	h.setCachedDirectory(directory{Users: []*ldap.Entry{
+		h.newUserEntry(alice, nil, nil, nil),
+		h.newUserEntry(bob, nil, nil, nil),
+	}})
+
+	search := func(filter string, attrs ...string) []*ldap.Entry {
+		req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: filter, Attributes: attrs}
+		res, err := h.Search(h.getUserDN("admin"), req, nil)
+		require.NoError(t, err)
+		return res.Entries
+	}
+
+	entries := search("(uid=alice)")
+	require.Len(t, entries, 1)
+	assert.Empty(t, entries[0].GetAttributeValue("entryUUID"), "operational attributes should not be returned by default")
+
+	entries = search("(uid=alice)", "*", "+")
+	require.Len(t, entries, 1)
+	assert.Equal(t, newEntryUUID("user", alice.ID), entries[0].GetAttributeValue("entryUUID"))
+	assert.Equal(t, "20200101000000Z", entries[0].GetAttributeValue("createTimestamp"))
+	assert.Equal(t, "20200701000000Z", entries[0].GetAttributeValue("modifyTimestamp"))
+	assert.Equal(t, "alice", entries[0].GetAttributeValue("uid"))
+
+	entries = search("(entryUUID="+newEntryUUID("user", bob.ID)+")", "entryUUID")
+	require.Len(t, entries, 1)
+	assert.Equal(t, h.getUserDN("bob"), entries[0].DN)
+	require.Len(t, entries[0].Attributes, 1)
+
+	entries = search("(modifyTimestamp>=20200615000000Z)")
+	require.Len(t, entries, 1)
+	assert.Equal(t, h.getUserDN("alice"), entries[0].DN)
+}
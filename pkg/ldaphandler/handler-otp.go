@@ -0,0 +1,138 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+)
+
+const (
+	totpLength         = 6
+	scratchTokenLength = 8
+)
+
+// splitUsernameOTP split username in the form of 'username;otp', the same workaround used by the caddy plugin
+func splitUsernameOTP(uname string) (string, string) {
+	if s := strings.Split(uname, ";"); len(s) == 2 {
+		return s[0], s[1]
+	}
+	return uname, ""
+}
+
+// canonicalDN strip the otp given in the username of the bound DN, so that the bound identity of gitea users
+// is the same with or without otp
+func (h *handler) canonicalDN(boundDN string) string {
+	if !h.otp {
+		return boundDN
+	}
+	uname, ok := parseChildDN(boundDN, h.userUAttr, h.userParentRDN, h.baseDN)
+	if !ok || !strings.Contains(uname, ";") {
+		return boundDN
+	}
+	uname, _ = splitUsernameOTP(uname)
+	return h.getUserDN(uname)
+}
+
+// signIn verify the user's password. When otp mode is enabled, the otp is either given separately
+// or appended to the password
+func (h *handler) signIn(uname, pw, otp string) (err error) {
+	if !h.otp {
+		_, err = h.models.UserSignIn(uname, pw)
+		return
+	}
+	if otp != "" {
+		return h.signInWithOTP(uname, pw, otp)
+	}
+
+	u, err := h.models.UserSignIn(uname, pw)
+	if err == nil {
+		return h.checkOTPNotRequired(u)
+	}
+	twofa := h.getEnrolledTwoFactor(uname)
+	if twofa == nil {
+		return
+	}
+	for _, n := range []int{totpLength, scratchTokenLength} {
+		if len(pw) <= n {
+			continue
+		}
+		u, e := h.models.UserSignIn(uname, pw[:len(pw)-n])
+		if e == nil && u.ID == twofa.UID && h.checkOTP(twofa, pw[len(pw)-n:]) == nil {
+			return nil
+		}
+	}
+	return
+}
+
+// getEnrolledTwoFactor return the two-factor of the user, or nil when the user does not exist or is not enrolled.
+// Only then the password may end with the otp
+func (h *handler) getEnrolledTwoFactor(uname string) *models.TwoFactor {
+	u, err := h.models.GetUserByName(uname)
+	if err != nil {
+		return nil
+	}
+	twofa, err := h.models.GetTwoFactorByUID(u.ID)
+	if err != nil {
+		return nil
+	}
+	return twofa
+}
+
+func (h *handler) signInWithOTP(uname, pw, otp string) (err error) {
+	u, err := h.models.UserSignIn(uname, pw)
+	if err != nil {
+		return
+	}
+	return h.verifyOTP(u, otp)
+}
+
+// checkOTPNotRequired refuse password-only sign in of users enrolled in two-factor authentication
+func (h *handler) checkOTPNotRequired(u *models.User) error {
+	if !h.requireOTP {
+		return nil
+	}
+	_, err := h.models.GetTwoFactorByUID(u.ID)
+	switch {
+	case models.IsErrTwoFactorNotEnrolled(err):
+		return nil
+	case err != nil:
+		return fmt.Errorf("get two-factor failed: %w", err)
+	}
+	return fmt.Errorf("two-factor authentication is required")
+}
+
+// verifyOTP validate the otp against the user's TOTP secret or scratch token. Like gitea's web sign in,
+// a passcode can only be used once and a used scratch token is replaced with a new one
+func (h *handler) verifyOTP(u *models.User, otp string) (err error) {
+	twofa, err := h.models.GetTwoFactorByUID(u.ID)
+	if err != nil {
+		return fmt.Errorf("get two-factor failed: %w", err)
+	}
+	return h.checkOTP(twofa, otp)
+}
+
+func (h *handler) checkOTP(twofa *models.TwoFactor, otp string) (err error) {
+	ok, err := twofa.ValidateTOTP(otp)
+	if err != nil {
+		return fmt.Errorf("validate totp failed: %w", err)
+	}
+	if ok && twofa.LastUsedPasscode != otp {
+		twofa.LastUsedPasscode = otp
+		if err = h.models.UpdateTwoFactor(twofa); err != nil {
+			return fmt.Errorf("update two-factor failed: %w", err)
+		}
+		return nil
+	}
+
+	if twofa.VerifyScratchToken(otp) {
+		if _, err = twofa.GenerateScratchToken(); err != nil {
+			return fmt.Errorf("invalidate scratch token failed: %w", err)
+		}
+		if err = h.models.UpdateTwoFactor(twofa); err != nil {
+			return fmt.Errorf("update two-factor failed: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid otp")
+}
@@ -0,0 +1,153 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/pquerna/otp/totp"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitUsernameOTP(t *testing.T) {
+	uname, otp := splitUsernameOTP("user;123456")
+	assert.Equal(t, "user", uname)
+	assert.Equal(t, "123456", otp)
+
+	uname, otp = splitUsernameOTP("user")
+	assert.Equal(t, "user", uname)
+	assert.Equal(t, "", otp)
+}
+
+func TestCanonicalDN(t *testing.T) {
+	h, err := New(WithOTP(false))
+	require.NoError(t, err)
+	assert.Equal(t, h.getUserDN("user"), h.canonicalDN("uid=User;123456,ou=users,dc=domain,dc=com"))
+	assert.Equal(t, "uid=user,ou=users,dc=domain,dc=com", h.canonicalDN("uid=user,ou=users,dc=domain,dc=com"))
+	assert.Equal(t, "uid=jenkins;1,ou=services,dc=domain,dc=com", h.canonicalDN("uid=jenkins;1,ou=services,dc=domain,dc=com"))
+
+	h, err = New()
+	require.NoError(t, err)
+	assert.Equal(t, "uid=user;123456,ou=users,dc=domain,dc=com", h.canonicalDN("uid=user;123456,ou=users,dc=domain,dc=com"),
+		"should keep the dn when otp is disabled")
+}
+
+func TestBindOTP(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "gitea", AccountName: "user"})
+	require.NoError(t, err)
+	twofa := &models.TwoFactor{UID: 1}
+	require.NoError(t, twofa.SetSecret(key.Secret()))
+	scratch, err := twofa.GenerateScratchToken()
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	require.NoError(t, err)
+
+	enrolled := &models.User{ID: 1, Name: "user"}
+	plain := &models.User{ID: 2, Name: "plain"}
+	notEnrolled := models.ErrTwoFactorNotEnrolled{UID: plain.ID}
+
+	data := []struct {
+		scenario string
+		required bool
+		user     *models.User
+		username string
+		password string
+
+		signIns  map[string]bool
+		lookup   bool
+		twofa    *models.TwoFactor
+		twofaErr error
+		updated  bool
+
+		code ldap.LDAPResultCode
+	}{
+		{
+			scenario: "OTPInUsername", user: enrolled, username: "user;" + code, password: "password",
+			signIns: map[string]bool{"password": true}, twofa: twofa, updated: true,
+			code: ldap.LDAPResultSuccess,
+		},
+		{
+			scenario: "OTPInPassword", user: enrolled, username: "user", password: "password" + code,
+			signIns: map[string]bool{"password" + code: false, "password": true}, lookup: true, twofa: twofa, updated: true,
+			code: ldap.LDAPResultSuccess,
+		},
+		{
+			scenario: "ScratchTokenInPassword", user: enrolled, username: "user", password: "password" + scratch,
+			signIns: map[string]bool{"password" + scratch: false, "password" + scratch[:2]: false, "password": true},
+			lookup:  true, twofa: twofa, updated: true,
+			code: ldap.LDAPResultSuccess,
+		},
+		{
+			scenario: "ReusedOTP", user: enrolled, username: "user;" + code, password: "password",
+			signIns: map[string]bool{"password": true}, twofa: &models.TwoFactor{Secret: twofa.Secret, LastUsedPasscode: code},
+			code: ldap.LDAPResultInvalidCredentials,
+		},
+		{
+			scenario: "InvalidOTP", user: enrolled, username: "user;000000", password: "password",
+			signIns: map[string]bool{"password": true}, twofa: twofa,
+			code: ldap.LDAPResultInvalidCredentials,
+		},
+		{
+			scenario: "PasswordOnlyAllowed", user: enrolled, username: "user", password: "password",
+			signIns: map[string]bool{"password": true},
+			code:    ldap.LDAPResultSuccess,
+		},
+		{
+			scenario: "PasswordOnlyRefused", required: true, user: enrolled, username: "user", password: "password",
+			signIns: map[string]bool{"password": true}, twofa: twofa,
+			code: ldap.LDAPResultInvalidCredentials,
+		},
+		{
+			scenario: "PasswordOnlyNotEnrolled", required: true, user: plain, username: "plain", password: "password",
+			signIns: map[string]bool{"password": true}, twofaErr: notEnrolled,
+			code: ldap.LDAPResultSuccess,
+		},
+		{
+			scenario: "NotEnrolledPasswordNotSplit", user: plain, username: "plain", password: "password" + code,
+			signIns: map[string]bool{"password" + code: false}, lookup: true, twofaErr: notEnrolled,
+			code: ldap.LDAPResultInvalidCredentials,
+		},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			h, err := New(WithOTP(dat.required))
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mdl := mock.NewMockModels(ctrl)
+			for pw, ok := range dat.signIns {
+				var signInErr error
+				if !ok {
+					signInErr = fmt.Errorf("invalid password")
+				}
+				mdl.EXPECT().UserSignIn(dat.user.Name, pw).Return(dat.user, signInErr)
+			}
+			if dat.lookup {
+				mdl.EXPECT().GetUserByName(dat.user.Name).Return(dat.user, nil)
+			}
+			if dat.twofa != nil || dat.twofaErr != nil {
+				tf := dat.twofa
+				if tf != nil {
+					copied := *tf
+					tf = &copied
+				}
+				mdl.EXPECT().GetTwoFactorByUID(dat.user.ID).Return(tf, dat.twofaErr)
+			}
+			if dat.updated {
+				mdl.EXPECT().UpdateTwoFactor(gomock.Any()).Return(nil)
+			}
+			h.models = mdl
+
+			dn := fmt.Sprintf("%s=%s,%s,%s", h.userUAttr, dat.username, h.userParentRDN, h.baseDN)
+			res, err := h.Bind(dn, dat.password, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, dat.code, res)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ldaphandler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nmcclain/ldap"
+)
+
+// maxPagedSearches limits the paged searches kept open for a connection. Saving more evicts the oldest one
+const maxPagedSearches = 16
+
+// pagedSearch is the remaining entries of a search request using the paged results control (RFC 2696)
+type pagedSearch struct {
+	boundDN string
+	request string
+	entries []*ldap.Entry
+	code    ldap.LDAPResultCode
+	saved   uint64 // order in which it was saved
+}
+
+// pagedRequest identify a search request regardless of its paged results control,
+// since subsequent requests must be identical except for the cookie
+func pagedRequest(searchReq ldap.SearchRequest) string {
+	return fmt.Sprintf("%s|%d|%d|%d|%t|%s|%s",
+		normalizeDN(searchReq.BaseDN), searchReq.Scope, searchReq.DerefAliases, searchReq.SizeLimit,
+		searchReq.TypesOnly, searchReq.Filter, strings.ToLower(strings.Join(searchReq.Attributes, ",")),
+	)
+}
+
+func getPagingControl(searchReq ldap.SearchRequest) *ldap.ControlPaging {
+	paging, _ := ldap.FindControl(searchReq.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	return paging
+}
+
+// connKey identify a client connection. It does not change when the connection is upgraded using StartTLS
+func connKey(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	return conn.LocalAddr().String() + "|" + conn.RemoteAddr().String()
+}
+
+func (h *handler) savePagedSearch(conn net.Conn, ps *pagedSearch) (cookie []byte, err error) {
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generate cookie failed: %w", err)
+	}
+	cookie = []byte(hex.EncodeToString(b))
+
+	h.pagesMu.Lock()
+	defer h.pagesMu.Unlock()
+	key := connKey(conn)
+	if h.pages[key] == nil {
+		h.pages[key] = map[string]*pagedSearch{}
+	}
+	if len(h.pages[key]) >= maxPagedSearches {
+		oldest := ""
+		for c, p := range h.pages[key] {
+			if oldest == "" || p.saved < h.pages[key][oldest].saved {
+				oldest = c
+			}
+		}
+		delete(h.pages[key], oldest)
+	}
+	h.pagesSaved++
+	ps.saved = h.pagesSaved
+	h.pages[key][string(cookie)] = ps
+	return
+}
+
+// takePagedSearch remove and return the paged search identified by the cookie
+func (h *handler) takePagedSearch(conn net.Conn, cookie []byte) (ps *pagedSearch, ok bool) {
+	h.pagesMu.Lock()
+	defer h.pagesMu.Unlock()
+	key := connKey(conn)
+	if ps, ok = h.pages[key][string(cookie)]; ok {
+		delete(h.pages[key], string(cookie))
+	}
+	return
+}
+
+func (h *handler) clearPagedSearches(conn net.Conn) {
+	h.pagesMu.Lock()
+	defer h.pagesMu.Unlock()
+	delete(h.pages, connKey(conn))
+}
+
+// nextPage return the next page of the search. The cookie is empty when there is no more page left
+func (h *handler) nextPage(conn net.Conn, ps *pagedSearch, size uint32) (res ldap.ServerSearchResult, err error) {
+	entries, cookie := ps.entries, []byte{}
+	if size > 0 && uint32(len(entries)) > size {
+		ps.entries, entries = entries[size:], entries[:size]
+		if cookie, err = h.savePagedSearch(conn, ps); err != nil {
+			return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultOperationsError}, err
+		}
+	}
+
+	code := ldap.LDAPResultCode(ldap.LDAPResultSuccess)
+	if len(cookie) == 0 {
+		code = ps.code
+	}
+	return ldap.ServerSearchResult{
+		Entries:    entries,
+		Referrals:  []string{},
+		Controls:   []ldap.Control{&ldap.ControlPaging{Cookie: cookie}},
+		ResultCode: code,
+	}, nil
+}
+
+// continuePagedSearch serve search request carrying cookie of a previous page
+func (h *handler) continuePagedSearch(boundDN string, searchReq ldap.SearchRequest, paging *ldap.ControlPaging, conn net.Conn) (res ldap.ServerSearchResult, err error) {
+	ps, ok := h.takePagedSearch(conn, paging.Cookie)
+	if !ok || !strings.EqualFold(ps.boundDN, boundDN) || ps.request != pagedRequest(searchReq) {
+		h.logger.Error("invalid_paging_cookie").WithFields("dn", boundDN)
+		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultUnwillingToPerform},
+			fmt.Errorf("Search Error: invalid paged results cookie")
+	}
+	if paging.PagingSize == 0 {
+		// client abandon the paged search
+		ps.entries, ps.code = nil, ldap.LDAPResultSuccess
+	}
+	return h.nextPage(conn, ps, paging.PagingSize)
+}
@@ -0,0 +1,141 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	ldapc "github.com/go-ldap/ldap/v3"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tPagingHandler(t *testing.T, ctrl *gomock.Controller, n int) (h *handler, dns []string) {
+	h, err := New()
+	require.NoError(t, err)
+
+	users := []*models.User{}
+	for i := 0; i < n; i++ {
+		users = append(users, &models.User{ID: int64(i), Name: fmt.Sprintf("user%d", i)})
+		dns = append(dns, h.getUserDN(fmt.Sprintf("user%d", i)))
+	}
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return(users, int64(len(users)), nil).AnyTimes()
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil).AnyTimes()
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{}, nil).AnyTimes()
+	mdl.EXPECT().
+		UserSignIn(gomock.Any(), gomock.Any()).
+		Return(&models.User{}, nil).AnyTimes()
+	h.models = mdl
+	return
+}
+
+func TestSearchPaging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	h, dns := tPagingHandler(t, ctrl, 5)
+
+	s, err := newLDAPTestServer(t, h)
+	require.NoError(t, err)
+	defer s.close()
+	l, err := ldapc.DialURL(s.url())
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.Bind(h.getUserDN("admin"), "secret"))
+
+	req := ldapc.NewSearchRequest(
+		h.baseDN.String(), ldapc.ScopeWholeSubtree, ldapc.NeverDerefAliases, 0, 0, false,
+		"(objectClass=inetOrgPerson)", []string{"uid"}, nil,
+	)
+	res, err := l.SearchWithPaging(req, 2)
+	require.NoError(t, err)
+	actual := []string{}
+	for _, entry := range res.Entries {
+		actual = append(actual, entry.DN)
+	}
+	assert.Equal(t, dns, actual, "should return all entries across pages")
+}
+
+func TestSearchPagingCookie(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	h, dns := tPagingHandler(t, ctrl, 3)
+
+	admin := h.getUserDN("admin")
+	h.searchers[h.getUserDN("other")] = true
+	req := func(size uint32, cookie []byte) ldap.SearchRequest {
+		return ldap.SearchRequest{
+			BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: "(uid=*)",
+			Controls: []ldap.Control{&ldap.ControlPaging{PagingSize: size, Cookie: cookie}},
+		}
+	}
+	cookieOf := func(res ldap.ServerSearchResult) []byte {
+		require.Len(t, res.Controls, 1)
+		return res.Controls[0].(*ldap.ControlPaging).Cookie
+	}
+
+	res, err := h.Search(admin, req(2, nil), nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	cookie := cookieOf(res)
+	require.NotEmpty(t, cookie)
+
+	_, err = h.Search(h.getUserDN("other"), req(2, cookie), nil)
+	assert.Error(t, err, "cookie should be tied to the bound dn")
+
+	res, err = h.Search(admin, req(2, nil), nil)
+	require.NoError(t, err)
+	cookie = cookieOf(res)
+	different := req(2, cookie)
+	different.Filter = "(uid=user1)"
+	res, err = h.Search(admin, different, nil)
+	assert.Error(t, err, "cookie should be tied to the search request")
+	assert.Equal(t, ldap.LDAPResultCode(ldap.LDAPResultUnwillingToPerform), res.ResultCode)
+
+	res, err = h.Search(admin, req(2, nil), nil)
+	require.NoError(t, err)
+	cookie = cookieOf(res)
+	res, err = h.Search(admin, req(2, cookie), nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, dns[2], res.Entries[0].DN)
+	assert.Empty(t, cookieOf(res), "last page should not have cookie")
+	_, err = h.Search(admin, req(2, cookie), nil)
+	assert.Error(t, err, "cookie should not be reusable")
+
+	res, err = h.Search(admin, req(1, nil), nil)
+	require.NoError(t, err)
+	cookie = cookieOf(res)
+	res, err = h.Search(admin, req(0, cookie), nil)
+	require.NoError(t, err)
+	assert.Empty(t, res.Entries, "zero size should abandon the paged search")
+	assert.Empty(t, cookieOf(res))
+
+	res, err = h.Search(admin, req(1, nil), nil)
+	require.NoError(t, err)
+	cookie = cookieOf(res)
+	require.NoError(t, h.Close(admin, nil))
+	_, err = h.Search(admin, req(1, cookie), nil)
+	assert.Error(t, err, "closing connection should discard its cookies")
+
+	cookies := [][]byte{}
+	for i := 0; i <= maxPagedSearches; i++ {
+		res, err = h.Search(admin, req(1, nil), nil)
+		require.NoError(t, err)
+		cookies = append(cookies, cookieOf(res))
+	}
+	_, err = h.Search(admin, req(1, cookies[0]), nil)
+	assert.Error(t, err, "oldest paged search should be evicted")
+	res, err = h.Search(admin, req(1, cookies[1]), nil)
+	require.NoError(t, err, "newer paged searches should be kept")
+	assert.Len(t, res.Entries, 1)
+}
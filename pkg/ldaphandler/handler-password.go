@@ -0,0 +1,52 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/nmcclain/ldap"
+)
+
+// ModifyPassword change the gitea password of the bound user as described in RFC 3062. Users may only
+// change their own password, must provide the old one, and the new password must satisfy gitea's password policy.
+// Users of external login sources, e.g. ldap or oauth2, can not change their password
+func (h *handler) ModifyPassword(boundDN, userIdentity, oldPassword, newPassword string, conn net.Conn) (ldap.LDAPResultCode, error) {
+	if !h.passwordModify {
+		return ldap.LDAPResultUnwillingToPerform, fmt.Errorf("Password Modify Error: password modification is disabled")
+	}
+	if boundDN == "" {
+		return ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("Password Modify Error: Anonymous BindDN not allowed")
+	}
+	boundDN = h.canonicalDN(boundDN)
+	if userIdentity != "" && normalizeDN(userIdentity) != normalizeDN(boundDN) {
+		h.logger.Error("insufficient_access_right").WithFields("dn", boundDN, "user_identity", userIdentity)
+		return ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("Password Modify Error: BindDN '%s' may only modify its own password", boundDN)
+	}
+	uname, ok := parseChildDN(boundDN, h.userUAttr, h.userParentRDN, h.baseDN)
+	if !ok {
+		return ldap.LDAPResultUnwillingToPerform, fmt.Errorf("Password Modify Error: BindDN '%s' is not a gitea user", boundDN)
+	}
+	if newPassword == "" {
+		return ldap.LDAPResultUnwillingToPerform, fmt.Errorf("Password Modify Error: password generation is not supported")
+	}
+	if oldPassword == "" {
+		return ldap.LDAPResultUnwillingToPerform, fmt.Errorf("Password Modify Error: old password is required")
+	}
+
+	user, err := h.models.UserSignIn(uname, oldPassword)
+	if err != nil {
+		h.logger.Error("gitea_sign_in_failed").WithFields("dn", boundDN, "error", err)
+		return ldap.LDAPResultInvalidCredentials, fmt.Errorf("Password Modify Error: invalid old password")
+	}
+	if !user.IsLocal() {
+		return ldap.LDAPResultUnwillingToPerform, fmt.Errorf("Password Modify Error: password of '%s' is managed by its login source", uname)
+	}
+	if err = h.models.CheckPasswordPolicy(newPassword); err != nil {
+		return ldap.LDAPResultConstraintViolation, fmt.Errorf("Password Modify Error: %w", err)
+	}
+	if err = h.models.UpdateUserPassword(user, newPassword); err != nil {
+		h.logger.Error("gitea_update_password_failed").WithFields("dn", boundDN, "error", err)
+		return ldap.LDAPResultOperationsError, fmt.Errorf("Password Modify Error: update password failed")
+	}
+	return ldap.LDAPResultSuccess, nil
+}
@@ -0,0 +1,97 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModifyPassword(t *testing.T) {
+	user := &models.User{ID: 1, Name: "alice", IsActive: true}
+	external := &models.User{ID: 1, Name: "alice", IsActive: true, LoginType: models.LoginLDAP, LoginSource: 1}
+
+	data := []struct {
+		scenario     string
+		disabled     bool
+		otp          bool
+		boundDN      string
+		userIdentity string
+		oldPassword  string
+		newPassword  string
+
+		external  bool
+		signInErr error
+		policyErr error
+		updateErr error
+		signIn    bool
+		check     bool
+		update    bool
+
+		code ldap.LDAPResultCode
+	}{
+		{scenario: "Success", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			signIn: true, check: true, update: true, code: ldap.LDAPResultSuccess},
+		{scenario: "ExplicitIdentity", boundDN: "uid=alice,ou=users,dc=domain,dc=com", userIdentity: "UID=Alice, OU=Users, DC=domain, DC=com",
+			oldPassword: "old", newPassword: "new-secret", signIn: true, check: true, update: true, code: ldap.LDAPResultSuccess},
+		{scenario: "OTPInBoundDN", otp: true, boundDN: "uid=alice;123456,ou=users,dc=domain,dc=com", userIdentity: "uid=alice,ou=users,dc=domain,dc=com",
+			oldPassword: "old", newPassword: "new-secret", signIn: true, check: true, update: true, code: ldap.LDAPResultSuccess},
+		{scenario: "Disabled", disabled: true, boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			code: ldap.LDAPResultUnwillingToPerform},
+		{scenario: "Anonymous", oldPassword: "old", newPassword: "new-secret", code: ldap.LDAPResultInsufficientAccessRights},
+		{scenario: "OtherUser", boundDN: "uid=alice,ou=users,dc=domain,dc=com", userIdentity: "uid=bob,ou=users,dc=domain,dc=com",
+			oldPassword: "old", newPassword: "new-secret", code: ldap.LDAPResultInsufficientAccessRights},
+		{scenario: "ServiceAccount", boundDN: "uid=jenkins,ou=services,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			code: ldap.LDAPResultUnwillingToPerform},
+		{scenario: "NoNewPassword", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", code: ldap.LDAPResultUnwillingToPerform},
+		{scenario: "NoOldPassword", boundDN: "uid=alice,ou=users,dc=domain,dc=com", newPassword: "new-secret", code: ldap.LDAPResultUnwillingToPerform},
+		{scenario: "InvalidOldPassword", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			signIn: true, signInErr: models.ErrUserNotExist{Name: "alice"}, code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "ExternalUser", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			external: true, signIn: true, code: ldap.LDAPResultUnwillingToPerform},
+		{scenario: "PolicyViolation", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new",
+			signIn: true, check: true, policyErr: fmt.Errorf("too short"), code: ldap.LDAPResultConstraintViolation},
+		{scenario: "UpdateFailed", boundDN: "uid=alice,ou=users,dc=domain,dc=com", oldPassword: "old", newPassword: "new-secret",
+			signIn: true, check: true, update: true, updateErr: fmt.Errorf("db down"), code: ldap.LDAPResultOperationsError},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			opts := Options()
+			if !dat.disabled {
+				opts = append(opts, WithPasswordModify())
+			}
+			if dat.otp {
+				opts = append(opts, WithOTP(false))
+			}
+			h, err := New(opts...)
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mdl := mock.NewMockModels(ctrl)
+			if dat.signIn {
+				u := user
+				if dat.external {
+					u = external
+				}
+				mdl.EXPECT().UserSignIn("alice", dat.oldPassword).Return(u, dat.signInErr)
+			}
+			if dat.check {
+				mdl.EXPECT().CheckPasswordPolicy(dat.newPassword).Return(dat.policyErr)
+			}
+			if dat.update {
+				mdl.EXPECT().UpdateUserPassword(user, dat.newPassword).Return(dat.updateErr)
+			}
+			h.models = mdl
+
+			code, err := h.ModifyPassword(dat.boundDN, dat.userIdentity, dat.oldPassword, dat.newPassword, nil)
+			assert.Equal(t, dat.code, code)
+			assert.Equal(t, dat.code != ldap.LDAPResultSuccess, err != nil)
+		})
+	}
+}
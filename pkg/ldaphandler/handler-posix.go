@@ -0,0 +1,56 @@
+package ldaphandler
+
+import (
+	"bytes"
+	"strconv"
+	"text/template"
+
+	"code.gitea.io/gitea/models"
+	"github.com/nmcclain/ldap"
+)
+
+// getUIDNumber return uidNumber of the user, which also serves as gidNumber of the user's private group.
+// Organizations are stored in the same table as users, so their gidNumber never collide with it
+func (h *handler) getUIDNumber(user *models.User) int64 {
+	return h.posixIDOffset + user.ID
+}
+
+func (h *handler) getOrgGIDNumber(org *models.User) int64 {
+	return h.posixIDOffset + org.ID
+}
+
+func (h *handler) getTeamGIDNumber(team *models.Team) int64 {
+	return h.posixTeamIDOffset + team.ID
+}
+
+func executeTemplate(tmpl *template.Template, user *models.User) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, user); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// newPosixAttributes return posixAccount's attributes of the user
+func (h *handler) newPosixAttributes(user *models.User) (attrs []*ldap.EntryAttribute) {
+	id := strconv.FormatInt(h.getUIDNumber(user), 10)
+	attrs = append(attrs, &ldap.EntryAttribute{Name: "uidNumber", Values: []string{id}})
+	attrs = append(attrs, &ldap.EntryAttribute{Name: "gidNumber", Values: []string{id}})
+
+	for _, tmpl := range []*template.Template{h.homeDirectory, h.loginShell} {
+		v, err := executeTemplate(tmpl, user)
+		if err != nil {
+			h.logger.Warn("execute_template_failed").WithFields("attribute", tmpl.Name(), "user", user.Name, "error", err)
+			continue
+		}
+		attrs = append(attrs, &ldap.EntryAttribute{Name: tmpl.Name(), Values: []string{v}})
+	}
+	return
+}
+
+// newPrivateGroupEntry return the user's private group, which is named after the user and has the user as its only member.
+// Gitea users and organizations share their names, so it never collide with the group of an organization
+func (h *handler) newPrivateGroupEntry(user *models.User, member groupMember) *ldap.Entry {
+	return h.newGroupEntry(h.groupParentRDN, h.getOrgDN(user.Name), user.Name, "", h.getUIDNumber(user), []groupMember{member},
+		newOperationalAttributes("private-group", user.ID, user.CreatedUnix, user.UpdatedUnix))
+}
@@ -0,0 +1,90 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPosixInvalidTemplate(t *testing.T) {
+	_, err := New(WithPosix(1000, 100000, "/home/{{.Name", "/bin/sh"))
+	assert.Error(t, err, "should reject unparsable template")
+	_, err = New(WithPosix(1000, 100000, "/home/{{.Unknown}}", "/bin/sh"))
+	assert.Error(t, err, "should reject template referencing unknown field")
+}
+
+func TestSearchPosix(t *testing.T) {
+	h, err := New(WithPosix(1000, 100000, "/home/{{.Name}}", "{{if .IsAdmin}}/bin/bash{{else}}/bin/sh{{end}}"))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true, IsAdmin: true}
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true}
+	org := &models.User{ID: 3, Name: "org", Type: models.UserTypeOrganization}
+	team := &models.Team{ID: 1, OrgID: org.ID, Name: "team"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return([]*models.User{alice, bob}, int64(2), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{org}, int64(1), nil).Times(2)
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{team}, nil).Times(3)
+	mdl.EXPECT().
+		SearchTeam(gomock.Any()).
+		Return([]*models.Team{team}, int64(1), nil)
+	mdl.EXPECT().
+		GetUsersByIDs([]int64{2}).
+		Return(models.UserList{bob}, nil)
+	h.models = mdl
+
+	attrs := func(entry *ldap.Entry) map[string][]string {
+		m := map[string][]string{}
+		for _, attr := range entry.Attributes {
+			m[attr.Name] = attr.Values
+		}
+		return m
+	}
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree,
+		Filter: "(|(objectClass=posixAccount)(objectClass=posixGroup))"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 6)
+
+	user := attrs(res.Entries[0])
+	assert.Equal(t, []string{"1001"}, user["uidNumber"])
+	assert.Equal(t, []string{"1001"}, user["gidNumber"])
+	assert.Equal(t, []string{"/home/alice"}, user["homeDirectory"])
+	assert.Equal(t, []string{"/bin/bash"}, user["loginShell"])
+	assert.Equal(t, []string{"inetorgperson", "posixaccount"}, user["objectClass"])
+	assert.Equal(t, []string{"/bin/sh"}, attrs(res.Entries[1])["loginShell"])
+
+	group := attrs(res.Entries[2])
+	assert.Equal(t, []string{"1003"}, group["gidNumber"])
+	assert.Equal(t, []string{"alice", "bob"}, group["memberUid"])
+	assert.Equal(t, []string{"groupofnames", "groupofuniquenames", "posixgroup"}, group["objectClass"])
+	assert.Equal(t, []string{"100001"}, attrs(res.Entries[3])["gidNumber"])
+
+	private := attrs(res.Entries[4])
+	assert.Equal(t, "cn=alice,ou=groups,dc=domain,dc=com", res.Entries[4].DN, "user should have a private group")
+	assert.Equal(t, user["gidNumber"], private["gidNumber"])
+	assert.Equal(t, []string{"alice"}, private["memberUid"])
+	assert.Equal(t, []string{h.getUserDN("alice")}, private["member"])
+	assert.Equal(t, []string{"1002"}, attrs(res.Entries[5])["gidNumber"])
+
+	req.Filter = "(&(objectClass=posixAccount)(uidNumber=1002))"
+	res, err = h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, h.getUserDN("bob"), res.Entries[0].DN)
+}
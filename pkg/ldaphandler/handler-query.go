@@ -0,0 +1,231 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+	"code.gitea.io/gitea/modules/structs"
+	"github.com/nmcclain/ldap"
+)
+
+type teamName struct {
+	org, team string
+}
+
+// userQuery is a filter translated into lookups of gitea users by id, name, email, organization, or team.
+// The retrieved users are only candidates, the filter still need to be evaluated against their entries
+type userQuery struct {
+	ids    []int64
+	names  []string
+	emails []string
+	orgs   []string
+	teams  []teamName
+}
+
+// newUserQuery translate the filter into userQuery. It returns nil when the filter may match
+// entries that can not be retrieved by userQuery, which include every groups, or when it refers to attributes
+// changed by the attribute mapping.
+func (h *handler) newUserQuery(f *filter) (q *userQuery) {
+	if h.mappings != nil && (h.mappings.Users.touches(f.attr) || h.mappings.Groups.touches(f.attr)) {
+		return nil
+	}
+	switch f.typ {
+	case filterAnd:
+		for _, child := range f.children {
+			if q = h.newUserQuery(child); q != nil {
+				return
+			}
+		}
+		return nil
+
+	case filterOr:
+		q = &userQuery{}
+		for _, child := range f.children {
+			cq := h.newUserQuery(child)
+			if cq == nil {
+				return nil
+			}
+			q.ids = append(q.ids, cq.ids...)
+			q.names = append(q.names, cq.names...)
+			q.emails = append(q.emails, cq.emails...)
+			q.orgs = append(q.orgs, cq.orgs...)
+			q.teams = append(q.teams, cq.teams...)
+		}
+		return
+
+	case filterEquality:
+		switch f.attr {
+		case strings.ToLower(h.userUAttr):
+			if strings.EqualFold(h.userUAttr, h.groupUAttr) {
+				return nil // groups may match as well
+			}
+			return &userQuery{names: []string{f.value}}
+
+		case "mail":
+			return &userQuery{emails: []string{f.value}}
+
+		case "userprincipalname":
+			q = &userQuery{}
+			if name, ok := h.parseUserPrincipalName(f.value); ok && h.profile == profileAD {
+				q.names = append(q.names, name)
+			}
+			return
+
+		case "uidnumber":
+			if !h.posix {
+				return &userQuery{}
+			}
+			q = &userQuery{}
+			if n, err := strconv.ParseInt(f.value, 10, 64); err == nil && n > h.posixIDOffset {
+				q.ids = append(q.ids, n-h.posixIDOffset)
+			}
+			return
+
+		case "memberof":
+			if h.isAdminGroupDN(f.value) {
+				return nil // administrators can not be queried
+			}
+			q = &userQuery{}
+			if org, team, ok := h.parseGroupDN(f.value); ok && team != "" {
+				q.teams = append(q.teams, teamName{org: org, team: team})
+			} else if ok {
+				q.orgs = append(q.orgs, org)
+			}
+			return
+		}
+	}
+	return nil
+}
+
+// parseGroupDN return organization and team name of a DN created by getOrgDN or getTeamDN
+func (h *handler) parseGroupDN(dn string) (org, team string, ok bool) {
+	name, ok := parseChildDN(dn, h.groupUAttr, h.groupParentRDN, h.baseDN)
+	if !ok {
+		return
+	}
+	if i := strings.IndexByte(name, '['); i > 0 && strings.HasSuffix(name, "]") {
+		return name[:i], name[i+1 : len(name)-1], true
+	}
+	return name, "", true
+}
+
+// isListed check whether the user would also be returned when listing users using default models.SearchUserOptions
+func isListed(u *models.User) bool {
+	return u.Type == models.UserTypeIndividual && u.Visibility == structs.VisibleTypePublic
+}
+
+func (h *handler) queryUsers(q *userQuery, orgs []*models.User) (users []*models.User, err error) {
+	seen := map[int64]bool{}
+	add := func(candidates []*models.User, match func(u *models.User) bool) {
+		for _, u := range candidates {
+			if !seen[u.ID] && isListed(u) && match(u) {
+				users = append(users, u)
+				seen[u.ID] = true
+			}
+		}
+	}
+	all := func(u *models.User) bool { return true }
+
+	if len(q.ids) > 0 {
+		found, err := h.models.GetUsersByIDs(q.ids)
+		if err != nil {
+			return nil, fmt.Errorf("get users by ids failed: %w", err)
+		}
+		add(found, all)
+	}
+
+	for _, name := range q.names {
+		u, err := h.models.GetUserByName(name)
+		if models.IsErrUserNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get gitea user by name failed: %w", err)
+		}
+		add([]*models.User{u}, all)
+	}
+
+	for _, email := range q.emails {
+		found, _, err := h.models.SearchUsers(&models.SearchUserOptions{Keyword: email, SearchByEmail: true})
+		if err != nil {
+			return nil, fmt.Errorf("search gitea users by email failed: %w", err)
+		}
+		add(found, func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+	}
+
+	orgByName := map[string]*models.User{}
+	for _, org := range orgs {
+		orgByName[strings.ToLower(org.Name)] = org
+	}
+
+	for _, name := range q.orgs {
+		org, ok := orgByName[name]
+		if !ok {
+			continue
+		}
+		ous, err := h.models.GetOrgUsersByOrgID(&models.FindOrgMembersOpts{OrgID: org.ID})
+		if err != nil {
+			return nil, fmt.Errorf("get organization's users failed: %w", err)
+		}
+		ids := make([]int64, 0, len(ous))
+		for _, ou := range ous {
+			ids = append(ids, ou.UID)
+		}
+		found, err := h.models.GetUsersByIDs(ids)
+		if err != nil {
+			return nil, fmt.Errorf("get users by ids failed: %w", err)
+		}
+		add(found, all)
+	}
+
+	for _, name := range q.teams {
+		org, ok := orgByName[name.org]
+		if !ok {
+			continue
+		}
+		team, err := h.models.GetTeam(org.ID, name.team)
+		if models.IsErrTeamNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get team failed: %w", err)
+		}
+		found, err := h.models.GetTeamMembers(team.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get team's members failed: %w", err)
+		}
+		add(found, all)
+	}
+	return
+}
+
+// listQueriedUsers return entries of users retrieved by the query
+func (h *handler) listQueriedUsers(q *userQuery) (entries []*ldap.Entry, err error) {
+	orgs, _, err := h.models.SearchUsers(&models.SearchUserOptions{Type: models.UserTypeOrganization})
+	if err != nil {
+		return nil, fmt.Errorf("search gitea organizations failed: %w", err)
+	}
+	orgByID := map[int64]*models.User{}
+	for _, org := range orgs {
+		orgByID[org.ID] = org
+	}
+
+	users, err := h.queryUsers(q, orgs)
+	if err != nil {
+		return
+	}
+	for _, user := range users {
+		teams, err := h.models.GetUserTeams(user.ID, models.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get user's teams failed: %w", err)
+		}
+		keys, err := h.listSSHPublicKeys(user)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, h.newUserEntry(user, orgByID, teams, keys))
+	}
+	return
+}
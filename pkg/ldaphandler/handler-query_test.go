@@ -0,0 +1,133 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserQuery(t *testing.T) {
+	h, err := New()
+	require.NoError(t, err)
+
+	data := map[string]*userQuery{
+		"(uid=alice)":                                   {names: []string{"alice"}},
+		"(mail=alice@domain.com)":                       {emails: []string{"alice@domain.com"}},
+		"(memberOf=" + h.getOrgDN("Org") + ")":          {orgs: []string{"org"}},
+		"(memberOf=" + h.getTeamDN("org", "Team") + ")": {teams: []teamName{{org: "org", team: "team"}}},
+		"(memberOf=cn=org,ou=other,dc=domain,dc=com)":   {},
+		"(&(objectClass=inetOrgPerson)(uid=alice))":     {names: []string{"alice"}},
+		"(|(uid=alice)(mail=bob@domain.com))":           {names: []string{"alice"}, emails: []string{"bob@domain.com"}},
+		"(|(uid=alice)(displayName=bob))":               nil,
+		"(uid=*)":                                       nil,
+		"(!(uid=alice))":                                nil,
+		"(cn=org)":                                      nil,
+	}
+	for s, expected := range data {
+		f, err := parseFilter(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, h.newUserQuery(f), s)
+	}
+}
+
+func TestNewUserQueryMapped(t *testing.T) {
+	file, cleanup := tMappingFile(t, `{
+		"users": {"templates": {"uidNumber": "{{.ID}}"}, "copy": {"displayName": ["mail"]}, "rename": {"mail": "email"}},
+		"groups": {"rename": {"member": "memberOf"}}
+	}`)
+	defer cleanup()
+	h, err := New(WithPosix(1000, 100000, "/home/{{.Name}}", "/bin/sh"), WithAttributeMapping(file))
+	require.NoError(t, err)
+
+	data := map[string]*userQuery{
+		"(uid=alice)":                           {names: []string{"alice"}},
+		"(uidNumber=1001)":                      nil,
+		"(mail=alice@domain.com)":               nil,
+		"(email=alice@domain.com)":              nil,
+		"(memberOf=" + h.getOrgDN("org") + ")":  nil,
+		"(&(uidNumber=1001)(uid=alice))":        {names: []string{"alice"}},
+		"(|(uid=alice)(mail=alice@domain.com))": nil,
+	}
+	for s, expected := range data {
+		f, err := parseFilter(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, h.newUserQuery(f), s)
+	}
+}
+
+func TestSearchQueried(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", Email: "alice@domain.com", IsActive: true}
+	alicia := &models.User{ID: 2, Name: "alicia", Email: "alicia@domain.com", IsActive: true}
+	bob := &models.User{ID: 3, Name: "bob", Email: "bob@domain.com", IsActive: true}
+	org := &models.User{ID: 4, Name: "org", Type: models.UserTypeOrganization}
+	team := &models.Team{ID: 5, OrgID: org.ID, Name: "team"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{org}, int64(1), nil).AnyTimes()
+	mdl.EXPECT().
+		GetUserByName("alice").
+		Return(alice, nil)
+	mdl.EXPECT().
+		GetUserByName("alicia").
+		Return(alicia, nil)
+	mdl.EXPECT().
+		GetUserByName("unknown").
+		Return(nil, models.ErrUserNotExist{Name: "unknown"})
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Keyword: "BOB@domain.com", SearchByEmail: true}}).
+		Return([]*models.User{bob}, int64(1), nil)
+	mdl.EXPECT().
+		GetOrgUsersByOrgID(reflectEq{&models.FindOrgMembersOpts{OrgID: org.ID}}).
+		Return([]*models.OrgUser{{OrgID: org.ID, UID: alice.ID}, {OrgID: org.ID, UID: org.ID}}, nil)
+	mdl.EXPECT().
+		GetUsersByIDs([]int64{alice.ID, org.ID}).
+		Return(models.UserList{alice, org}, nil)
+	mdl.EXPECT().
+		GetTeam(org.ID, "team").
+		Return(team, nil)
+	mdl.EXPECT().
+		GetTeamMembers(team.ID).
+		Return([]*models.User{bob}, nil)
+	mdl.EXPECT().
+		GetTeam(org.ID, "unknown").
+		Return(nil, models.ErrTeamNotExist{OrgID: org.ID, Name: "unknown"})
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{team}, nil).AnyTimes()
+	h.models = mdl
+
+	data := []struct {
+		filter string
+		dns    []string
+	}{
+		{filter: "(uid=alice)", dns: []string{h.getUserDN("alice")}},
+		{filter: "(|(uid=alicia)(uid=unknown))", dns: []string{h.getUserDN("alicia")}},
+		{filter: "(mail=BOB@domain.com)", dns: []string{h.getUserDN("bob")}},
+		{filter: "(memberOf=" + h.getOrgDN("org") + ")", dns: []string{h.getUserDN("alice")}},
+		{filter: "(&(objectClass=inetOrgPerson)(memberOf=" + h.getTeamDN("org", "team") + "))", dns: []string{h.getUserDN("bob")}},
+		{filter: "(memberOf=" + h.getTeamDN("org", "unknown") + ")", dns: nil},
+		{filter: "(memberOf=" + h.getOrgDN("unknown") + ")", dns: nil},
+	}
+	for _, dat := range data {
+		req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: dat.filter}
+		res, err := h.Search(h.getUserDN("admin"), req, nil)
+		require.NoError(t, err, dat.filter)
+		dns := []string(nil)
+		for _, entry := range res.Entries {
+			dns = append(dns, entry.DN)
+		}
+		assert.Equal(t, dat.dns, dns, dat.filter)
+	}
+}
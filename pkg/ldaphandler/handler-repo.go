@@ -0,0 +1,75 @@
+package ldaphandler
+
+import (
+	"fmt"
+
+	"code.gitea.io/gitea/models"
+	"github.com/nmcclain/ldap"
+)
+
+// repoAccessLevels are the access levels having their own group when repository groups are per access level
+var repoAccessLevels = []struct {
+	name string
+	mode models.AccessMode
+}{
+	{name: "read", mode: models.AccessModeRead},
+	{name: "write", mode: models.AccessModeWrite},
+	{name: "admin", mode: models.AccessModeAdmin},
+}
+
+func (h *handler) getRepoDN(owner, repo, level string) string {
+	if level == "" {
+		return fmt.Sprintf("%s=%s/%s,%s,%s", h.groupUAttr, owner, repo, h.repoParentRDN, h.baseDN)
+	}
+	return fmt.Sprintf("%s=%s/%s[%s],%s,%s", h.groupUAttr, owner, repo, level, h.repoParentRDN, h.baseDN)
+}
+
+// listRepoMembers return members of the repository group having at least the access mode.
+// Users that are not listed, e.g. organizations or private users, are skipped
+func (h *handler) listRepoMembers(repo *models.Repository, mode models.AccessMode, members *groupMembers) (res []groupMember, err error) {
+	users, err := h.models.GetRepoUsersByAccessMode(repo, mode)
+	if err != nil {
+		return nil, fmt.Errorf("get repository's users failed: %w", err)
+	}
+	for _, user := range users {
+		if member, ok := members.byUser[user.ID]; ok {
+			res = append(res, member)
+		}
+	}
+	return
+}
+
+// listRepoGroups return one entry for every repository, or for every repository and access level
+func (h *handler) listRepoGroups(members *groupMembers) (entries []*ldap.Entry, err error) {
+	repos, _, err := h.models.SearchRepository(&models.SearchRepoOptions{Private: true})
+	if err != nil {
+		return nil, fmt.Errorf("search gitea repositories failed: %w", err)
+	}
+
+	for _, repo := range repos {
+		name := repo.FullName()
+		if !h.repoGroupsPerAccessLevel {
+			readers, err := h.listRepoMembers(repo, models.AccessModeRead, members)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, h.newGroupEntry(h.repoParentRDN,
+				h.getRepoDN(repo.OwnerName, repo.Name, ""), name, repo.Description, 0, readers,
+				newOperationalAttributes("repo", repo.ID, repo.CreatedUnix, repo.UpdatedUnix),
+			))
+			continue
+		}
+
+		for _, level := range repoAccessLevels {
+			users, err := h.listRepoMembers(repo, level.mode, members)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, h.newGroupEntry(h.repoParentRDN,
+				h.getRepoDN(repo.OwnerName, repo.Name, level.name), fmt.Sprintf("%s[%s]", name, level.name), repo.Description, 0, users,
+				newOperationalAttributes("repo-"+level.name, repo.ID, repo.CreatedUnix, repo.UpdatedUnix),
+			))
+		}
+	}
+	return
+}
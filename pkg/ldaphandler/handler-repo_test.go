@@ -0,0 +1,78 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchRepoGroups(t *testing.T) {
+	h, err := New(WithRepoGroups(true), WithPosix(1000, 100000, "/home/{{.Name}}", "/bin/sh"), WithCache(16*1024*1024, 60))
+	require.NoError(t, err)
+
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true}
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true}
+	private := &models.User{ID: 3, Name: "private", IsActive: true}
+	repo := &models.Repository{ID: 1, OwnerName: "alice", Name: "app", Description: "the app"}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return([]*models.User{alice, bob}, int64(2), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return([]*models.User{}, int64(0), nil)
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{}, nil).Times(2)
+	mdl.EXPECT().
+		SearchRepository(reflectEq{&models.SearchRepoOptions{Private: true}}).
+		Return(models.RepositoryList{repo}, int64(1), nil)
+	mdl.EXPECT().
+		GetRepoUsersByAccessMode(repo, models.AccessModeRead).
+		Return([]*models.User{alice, bob, private}, nil)
+	mdl.EXPECT().
+		GetRepoUsersByAccessMode(repo, models.AccessModeWrite).
+		Return([]*models.User{alice, bob}, nil)
+	mdl.EXPECT().
+		GetRepoUsersByAccessMode(repo, models.AccessModeAdmin).
+		Return([]*models.User{alice}, nil)
+	h.models = mdl
+
+	attrs := func(entry *ldap.Entry) map[string][]string {
+		m := map[string][]string{}
+		for _, attr := range entry.Attributes {
+			m[attr.Name] = attr.Values
+		}
+		return m
+	}
+
+	req := ldap.SearchRequest{BaseDN: "ou=repos," + h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: "(objectClass=groupOfNames)"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 3)
+
+	assert.Equal(t, "cn=alice/app[read],ou=repos,dc=domain,dc=com", res.Entries[0].DN)
+	read := attrs(res.Entries[0])
+	assert.Equal(t, []string{"alice/app[read]"}, read["cn"])
+	assert.Equal(t, []string{"the app"}, read["description"])
+	assert.Equal(t, []string{h.getUserDN("alice"), h.getUserDN("bob")}, read["member"], "unlisted user should be skipped")
+	assert.Equal(t, []string{"groupofnames", "groupofuniquenames"}, read["objectClass"])
+	assert.Equal(t, []string{"repos"}, read["ou"])
+	assert.NotContains(t, read, "gidNumber")
+	assert.Equal(t, []string{h.getUserDN("alice"), h.getUserDN("bob")}, attrs(res.Entries[1])["member"])
+	assert.Equal(t, []string{h.getUserDN("alice")}, attrs(res.Entries[2])["member"])
+
+	req.Filter = "(member=" + h.getUserDN("bob") + ")"
+	res, err = h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	assert.Equal(t, h.getRepoDN("alice", "app", "write"), res.Entries[1].DN)
+}
@@ -0,0 +1,117 @@
+package ldaphandler
+
+import (
+	"fmt"
+
+	"github.com/nmcclain/ldap"
+)
+
+const subschemaDN = "cn=subschema"
+
+// giteatyOID is the arc of attribute types and object classes defined by giteaty. It is derived from a UUID as in
+// ITU-T X.667, so it does not need to be registered. Attribute types are under .1 and object classes under .2
+const giteatyOID = "2.25.259012459448743949947725183165994816328"
+
+// schemaAttributeTypes describe attributes emitted by the handler using RFC 4512 syntax
+var schemaAttributeTypes = []string{
+	"( 2.5.4.0 NAME 'objectClass' EQUALITY objectIdentifierMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.38 )",
+	"( 2.5.4.3 NAME 'cn' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	"( 2.5.4.11 NAME 'ou' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	"( 2.5.4.13 NAME 'description' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	"( 2.5.4.31 NAME 'member' EQUALITY distinguishedNameMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 )",
+	"( 2.5.4.50 NAME 'uniqueMember' EQUALITY uniqueMemberMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.34 )",
+	"( 0.9.2342.19200300.100.1.1 NAME 'uid' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	"( 0.9.2342.19200300.100.1.3 NAME 'mail' EQUALITY caseIgnoreIA5Match SUBSTR caseIgnoreIA5SubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 )",
+	"( 0.9.2342.19200300.100.1.25 NAME 'dc' EQUALITY caseIgnoreIA5Match SUBSTR caseIgnoreIA5SubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 SINGLE-VALUE )",
+	"( 2.16.840.1.113730.3.1.241 NAME 'displayName' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	"( 1.2.840.113556.1.2.102 NAME 'memberOf' EQUALITY distinguishedNameMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 NO-USER-MODIFICATION )",
+	"( 2.16.840.1.113719.1.1.4.1.23 NAME 'loginDisabled' SYNTAX 1.3.6.1.4.1.1466.115.121.1.7 SINGLE-VALUE )",
+	"( 1.3.6.1.1.1.1.0 NAME 'uidNumber' EQUALITY integerMatch ORDERING integerOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	"( 1.3.6.1.1.1.1.1 NAME 'gidNumber' EQUALITY integerMatch ORDERING integerOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	"( 1.3.6.1.1.1.1.3 NAME 'homeDirectory' EQUALITY caseExactIA5Match SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 SINGLE-VALUE )",
+	"( 1.3.6.1.1.1.1.4 NAME 'loginShell' EQUALITY caseExactIA5Match SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 SINGLE-VALUE )",
+	"( 1.3.6.1.1.1.1.12 NAME 'memberUid' EQUALITY caseExactIA5Match SUBSTR caseExactIA5SubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.26 )",
+	"( 1.3.6.1.4.1.24552.500.1.1.1.13 NAME 'sshPublicKey' EQUALITY octetStringMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.40 )",
+	"( 1.3.6.1.1.16.4 NAME 'entryUUID' EQUALITY UUIDMatch ORDERING UUIDOrderingMatch SYNTAX 1.3.6.1.1.16.1 SINGLE-VALUE NO-USER-MODIFICATION USAGE directoryOperation )",
+	"( 2.5.18.1 NAME 'createTimestamp' EQUALITY generalizedTimeMatch ORDERING generalizedTimeOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE NO-USER-MODIFICATION USAGE directoryOperation )",
+	"( 2.5.18.2 NAME 'modifyTimestamp' EQUALITY generalizedTimeMatch ORDERING generalizedTimeOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE NO-USER-MODIFICATION USAGE directoryOperation )",
+	"( 1.2.840.113556.1.4.221 NAME 'sAMAccountName' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	"( 1.2.840.113556.1.4.656 NAME 'userPrincipalName' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	"( 1.2.840.113556.1.4.8 NAME 'userAccountControl' EQUALITY integerMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	"( 1.2.840.113556.1.4.750 NAME 'groupType' EQUALITY integerMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )",
+	"( 1.2.840.113556.1.4.782 NAME 'objectCategory' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+	"( " + giteatyOID + ".1.1 NAME 'isAdmin' EQUALITY booleanMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.7 SINGLE-VALUE )",
+	"( " + giteatyOID + ".1.2 NAME 'isRestricted' EQUALITY booleanMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.7 SINGLE-VALUE )",
+	"( " + giteatyOID + ".1.3 NAME 'prohibitLogin' EQUALITY booleanMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.7 SINGLE-VALUE )",
+}
+
+// schemaObjectClasses describe object classes emitted by the handler using RFC 4512 syntax
+var schemaObjectClasses = []string{
+	"( 2.5.6.0 NAME 'top' ABSTRACT MUST objectClass )",
+	"( 2.5.6.9 NAME 'groupOfNames' SUP top STRUCTURAL MUST ( member $ cn ) MAY ( description $ ou ) )",
+	"( 2.5.6.17 NAME 'groupOfUniqueNames' SUP top STRUCTURAL MUST ( uniqueMember $ cn ) MAY ( description $ ou ) )",
+	"( 2.16.840.1.113730.3.2.2 NAME 'inetOrgPerson' SUP top STRUCTURAL MAY ( uid $ mail $ displayName $ ou $ dc $ memberOf $ loginDisabled ) )",
+	"( 1.3.6.1.1.1.2.0 NAME 'posixAccount' SUP top AUXILIARY MUST ( uid $ uidNumber $ gidNumber $ homeDirectory ) MAY ( loginShell $ description ) )",
+	"( 1.3.6.1.1.1.2.2 NAME 'posixGroup' SUP top AUXILIARY MUST ( cn $ gidNumber ) MAY ( memberUid $ description ) )",
+	"( 1.3.6.1.4.1.24552.500.1.1.2.0 NAME 'ldapPublicKey' SUP top AUXILIARY MUST ( sshPublicKey $ uid ) )",
+	"( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MAY ( cn $ description ) )",
+	"( 2.5.6.7 NAME 'organizationalPerson' SUP person STRUCTURAL MAY ( ou ) )",
+	"( 1.2.840.113556.1.5.9 NAME 'user' SUP organizationalPerson STRUCTURAL MAY ( sAMAccountName $ userPrincipalName $ userAccountControl $ objectCategory $ mail $ displayName $ memberOf $ dc ) )",
+	"( 1.2.840.113556.1.5.8 NAME 'group' SUP top STRUCTURAL MUST ( groupType ) MAY ( cn $ sAMAccountName $ member $ description $ objectCategory $ ou $ dc ) )",
+	"( " + giteatyOID + ".2.1 NAME 'giteaUser' SUP top AUXILIARY MAY ( isAdmin $ isRestricted $ prohibitLogin ) )",
+	"( 2.5.20.1 NAME 'subschema' AUXILIARY MAY ( attributeTypes $ objectClasses ) )",
+}
+
+// newRootDSE return the root DSE. Except objectClass, its attributes are operational as in RFC 4512, so they
+// are only returned when requested explicitly or using '+'
+func (h *handler) newRootDSE() *ldap.Entry {
+	return &ldap.Entry{DN: "", Attributes: []*ldap.EntryAttribute{
+		{Name: "objectClass", Values: []string{"top"}},
+		{Name: "namingContexts", Values: []string{h.baseDN.String()}},
+		{Name: "subschemaSubentry", Values: []string{subschemaDN}},
+		{Name: "supportedLDAPVersion", Values: []string{"3"}},
+		{Name: "supportedControl", Values: []string{ldap.ControlTypePaging}},
+		{Name: "supportedExtension", Values: h.extensions},
+		{Name: "vendorName", Values: []string{"giteaty"}},
+	}}
+}
+
+func newSubschemaEntry() *ldap.Entry {
+	return &ldap.Entry{DN: subschemaDN, Attributes: []*ldap.EntryAttribute{
+		{Name: "objectClass", Values: []string{"top", "subschema"}},
+		{Name: "cn", Values: []string{"subschema"}},
+		{Name: "attributeTypes", Values: schemaAttributeTypes},
+		{Name: "objectClasses", Values: schemaObjectClasses},
+	}}
+}
+
+// searchRootDSE serve base scoped search on the root DSE and the subschema entry, which are readable
+// by any client including anonymous one so that it can discover the server before binding
+func (h *handler) searchRootDSE(searchReq ldap.SearchRequest) (res ldap.ServerSearchResult, ok bool, err error) {
+	if searchReq.Scope != ldap.ScopeBaseObject {
+		return
+	}
+	var entry *ldap.Entry
+	switch normalizeDN(searchReq.BaseDN) {
+	case "":
+		entry = h.newRootDSE()
+	case subschemaDN:
+		entry = newSubschemaEntry()
+	default:
+		return
+	}
+
+	f, err := parseFilter(searchReq.Filter)
+	if err != nil {
+		h.logger.Error("invalid_filter").WithFields("filter", searchReq.Filter, "error", err)
+		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultOperationsError}, true,
+			fmt.Errorf("Search Error: error parsing filter: %s", searchReq.Filter)
+	}
+	entries, code := searchEntries([]*ldap.Entry{entry}, searchReq, f)
+	return ldap.ServerSearchResult{
+		Entries:    entries,
+		Referrals:  []string{},
+		Controls:   []ldap.Control{},
+		ResultCode: code,
+	}, true, nil
+}
@@ -0,0 +1,77 @@
+package ldaphandler
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchRootDSE(t *testing.T) {
+	h, err := New(WithSupportedExtensions([]string{"1.3.6.1.4.1.1466.20037"}))
+	require.NoError(t, err)
+
+	req := ldap.SearchRequest{BaseDN: "", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)",
+		Attributes: []string{"namingContexts", "supportedControl", "supportedExtension", "subschemaSubentry"}}
+	res, err := h.Search("", req, nil)
+	require.NoError(t, err, "root dse should be readable anonymously")
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, "", res.Entries[0].DN)
+	assert.Equal(t, []*ldap.EntryAttribute{
+		{Name: "namingContexts", Values: []string{"dc=domain,dc=com"}},
+		{Name: "subschemaSubentry", Values: []string{"cn=subschema"}},
+		{Name: "supportedControl", Values: []string{ldap.ControlTypePaging}},
+		{Name: "supportedExtension", Values: []string{"1.3.6.1.4.1.1466.20037"}},
+	}, res.Entries[0].Attributes)
+
+	req = ldap.SearchRequest{BaseDN: "", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)"}
+	res, err = h.Search("", req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, []*ldap.EntryAttribute{{Name: "objectClass", Values: []string{"top"}}}, res.Entries[0].Attributes,
+		"root dse attributes should be operational")
+
+	req.Attributes = []string{"+"}
+	res, err = h.Search("", req, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	names := []string{}
+	for _, attr := range res.Entries[0].Attributes {
+		names = append(names, attr.Name)
+	}
+	assert.Equal(t, []string{"namingContexts", "subschemaSubentry", "supportedLDAPVersion", "supportedControl",
+		"supportedExtension", "vendorName"}, names, "'+' should return root dse attributes")
+
+	req = ldap.SearchRequest{BaseDN: "cn=Subschema", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=subschema)",
+		Attributes: []string{"attributeTypes", "objectClasses"}}
+	res, err = h.Search("", req, nil)
+	require.NoError(t, err, "subschema should be readable anonymously")
+	require.Len(t, res.Entries, 1)
+	assert.Equal(t, "cn=subschema", res.Entries[0].DN)
+	assert.Equal(t, []*ldap.EntryAttribute{
+		{Name: "attributeTypes", Values: schemaAttributeTypes},
+		{Name: "objectClasses", Values: schemaObjectClasses},
+	}, res.Entries[0].Attributes)
+	for _, def := range schemaAttributeTypes {
+		assert.NotContains(t, def, " SUP ", "attribute types should not depend on undefined super types")
+	}
+
+	req = ldap.SearchRequest{BaseDN: "", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=person)"}
+	res, err = h.Search("", req, nil)
+	require.NoError(t, err)
+	assert.Empty(t, res.Entries, "filter should be evaluated against root dse")
+
+	req = ldap.SearchRequest{BaseDN: "", Scope: ldap.ScopeWholeSubtree, Filter: "(objectClass=*)"}
+	res, err = h.Search("", req, nil)
+	assert.Error(t, err, "only base scope search should be allowed")
+	assert.Equal(t, ldap.LDAPResultCode(ldap.LDAPResultInsufficientAccessRights), res.ResultCode)
+}
+
+func TestSchemaOIDs(t *testing.T) {
+	numericOID := regexp.MustCompile(`^\( [0-9]+(\.[0-9]+)+ NAME `)
+	for _, def := range append(append([]string{}, schemaAttributeTypes...), schemaObjectClasses...) {
+		assert.Regexp(t, numericOID, def)
+	}
+}
@@ -0,0 +1,71 @@
+package ldaphandler
+
+import (
+	"strings"
+
+	"github.com/nmcclain/ldap"
+)
+
+// inScope check whether dn is within the scope of the search. Both dn and baseDN must be normalized
+func inScope(dn, baseDN string, scope int) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == baseDN
+
+	case ldap.ScopeSingleLevel:
+		i := strings.IndexByte(dn, ',')
+		return i >= 0 && dn[i+1:] == baseDN
+
+	default:
+		return baseDN == "" || dn == baseDN || strings.HasSuffix(dn, ","+baseDN)
+	}
+}
+
+// selectAttributes return a copy of entry containing only the requested attributes.
+// Operational attributes are only included when requested by name or by '+'
+func selectAttributes(entry *ldap.Entry, names []string, typesOnly bool) *ldap.Entry {
+	all, operational, requested := len(names) == 0, false, map[string]bool{}
+	for _, name := range names {
+		switch name {
+		case "*":
+			all = true
+		case "+":
+			operational = true
+		case "", "1.1":
+		default:
+			requested[strings.ToLower(name)] = true
+		}
+	}
+
+	res := &ldap.Entry{DN: entry.DN}
+	for _, attr := range entry.Attributes {
+		if isOperationalAttribute(attr.Name) {
+			if !operational && !requested[strings.ToLower(attr.Name)] {
+				continue
+			}
+		} else if !all && !requested[strings.ToLower(attr.Name)] {
+			continue
+		}
+		values := attr.Values
+		if typesOnly {
+			values = nil
+		}
+		res.Attributes = append(res.Attributes, &ldap.EntryAttribute{Name: attr.Name, Values: values})
+	}
+	return res
+}
+
+// searchEntries return entries that match the search request along with the result code
+func searchEntries(entries []*ldap.Entry, searchReq ldap.SearchRequest, f *filter) (res []*ldap.Entry, code ldap.LDAPResultCode) {
+	baseDN := normalizeDN(searchReq.BaseDN)
+	for _, entry := range entries {
+		if !inScope(normalizeDN(entry.DN), baseDN, searchReq.Scope) || !f.match(entry) {
+			continue
+		}
+		if searchReq.SizeLimit > 0 && len(res) >= searchReq.SizeLimit {
+			return res, ldap.LDAPResultSizeLimitExceeded
+		}
+		res = append(res, selectAttributes(entry, searchReq.Attributes, searchReq.TypesOnly))
+	}
+	return res, ldap.LDAPResultSuccess
+}
@@ -3,6 +3,7 @@ package ldaphandler
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -191,10 +192,15 @@ func TestSearch(t *testing.T) {
 			GetUserTeams(gomock.Eq(dat.user.ID), reflectEq{models.ListOptions{}}).
 			Return(dat.teams, nil).Times(cacheMissCount)
 	}
+	for _, group := range groups {
+		mdl.EXPECT().
+			SearchTeam(reflectEq{&models.SearchTeamOptions{OrgID: group.ID, ListOptions: models.ListOptions{PageSize: -1}}}).
+			Return([]*models.Team{}, int64(0), nil).Times(cacheMissCount)
+	}
 	h.models = mdl
 
 	filter := "(&(objectClass=InetOrgPerson)(uid=*))"
-	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Filter: filter}
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: filter}
 	for i := 0; i < cacheMissCount; i++ {
 		for j := 0; j < 3; j++ {
 			res, err := h.Search(h.getUserDN("admin"), req, nil)
@@ -208,6 +214,109 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchGroups(t *testing.T) {
+	h, err := New()
+	require.NoError(t, err)
+
+	users := []*models.User{
+		{ID: 1, Name: "user"},
+		{ID: 2, Name: "user1"},
+	}
+	orgs := []*models.User{
+		{ID: 3, Name: "org", Description: "the org"},
+		{ID: 4, Name: "org1"},
+	}
+	teamsByOrg := map[int64][]*models.Team{
+		3: {
+			{ID: 1, OrgID: 3, Name: "team", Description: "the team"},
+			{ID: 2, OrgID: 3, Name: "team1"},
+		},
+		4: {
+			{ID: 3, OrgID: 4, Name: "empty"},
+		},
+	}
+	teamsByUser := map[int64][]*models.Team{
+		1: {teamsByOrg[3][0], teamsByOrg[3][1]},
+		2: {teamsByOrg[3][1]},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return(users, int64(len(users)), nil)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return(orgs, int64(len(orgs)), nil)
+	for _, user := range users {
+		mdl.EXPECT().
+			GetUserTeams(gomock.Eq(user.ID), reflectEq{models.ListOptions{}}).
+			Return(teamsByUser[user.ID], nil)
+	}
+	for _, org := range orgs {
+		mdl.EXPECT().
+			SearchTeam(reflectEq{&models.SearchTeamOptions{OrgID: org.ID, ListOptions: models.ListOptions{PageSize: -1}}}).
+			Return(teamsByOrg[org.ID], int64(len(teamsByOrg[org.ID])), nil)
+	}
+	h.models = mdl
+
+	groupAttrs := func(attrs ...*ldap.EntryAttribute) []*ldap.EntryAttribute {
+		return append(attrs,
+			&ldap.EntryAttribute{Name: "objectClass", Values: []string{"groupofnames", "groupofuniquenames"}},
+			&ldap.EntryAttribute{Name: "ou", Values: []string{"groups"}},
+			&ldap.EntryAttribute{Name: "dc", Values: []string{"domain", "com"}},
+		)
+	}
+	both := []string{h.getUserDN("user"), h.getUserDN("user1")}
+	entries := []*ldap.Entry{
+		{
+			DN: h.getOrgDN("org"),
+			Attributes: groupAttrs(
+				&ldap.EntryAttribute{Name: "cn", Values: []string{"org"}},
+				&ldap.EntryAttribute{Name: "description", Values: []string{"the org"}},
+				&ldap.EntryAttribute{Name: "member", Values: both},
+				&ldap.EntryAttribute{Name: "uniqueMember", Values: both},
+			),
+		},
+		{
+			DN: h.getTeamDN("org", "team"),
+			Attributes: groupAttrs(
+				&ldap.EntryAttribute{Name: "cn", Values: []string{"org[team]"}},
+				&ldap.EntryAttribute{Name: "description", Values: []string{"the team"}},
+				&ldap.EntryAttribute{Name: "member", Values: []string{h.getUserDN("user")}},
+				&ldap.EntryAttribute{Name: "uniqueMember", Values: []string{h.getUserDN("user")}},
+			),
+		},
+		{
+			DN: h.getTeamDN("org", "team1"),
+			Attributes: groupAttrs(
+				&ldap.EntryAttribute{Name: "cn", Values: []string{"org[team1]"}},
+				&ldap.EntryAttribute{Name: "member", Values: both},
+				&ldap.EntryAttribute{Name: "uniqueMember", Values: both},
+			),
+		},
+		{
+			DN: h.getOrgDN("org1"),
+			Attributes: groupAttrs(
+				&ldap.EntryAttribute{Name: "cn", Values: []string{"org1"}},
+			),
+		},
+		{
+			DN: h.getTeamDN("org1", "empty"),
+			Attributes: groupAttrs(
+				&ldap.EntryAttribute{Name: "cn", Values: []string{"org1[empty]"}},
+			),
+		},
+	}
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree, Filter: "(&(objectClass=groupOfNames)(cn=*))"}
+	res, err := h.Search(h.getUserDN("admin"), req, nil)
+	require.NoError(t, err, "should not return error")
+	assert.Equal(t, ldap.LDAPResultCode(ldap.LDAPResultSuccess), res.ResultCode)
+	assert.ElementsMatchf(t, entries, res.Entries, "\n%s\nshould match\n%s", toJSONString(res.Entries), toJSONString(entries))
+}
+
 func TestSearchInvalid(t *testing.T) {
 	h, err := New()
 	require.NoError(t, err)
@@ -250,10 +359,10 @@ func TestSearchInvalid(t *testing.T) {
 			result:   ldap.LDAPResultInsufficientAccessRights,
 		},
 		{
-			scenario: "UnknownObjectClass",
+			scenario: "InvalidFilter",
 			bindDN:   "uid=admin,ou=users,dc=domain,dc=com",
 			baseDN:   "dc=domain,dc=com",
-			filter:   "(&(objectClass=person)(uid=*))",
+			filter:   "(&(objectClass=person)(uid=*)",
 			result:   ldap.LDAPResultOperationsError,
 		},
 	}
@@ -268,3 +377,154 @@ func TestSearchInvalid(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchRequest(t *testing.T) {
+	h, err := New()
+	require.NoError(t, err)
+
+	users := []*models.User{
+		{ID: 1, Name: "alice", Email: "alice@domain.com", FullName: "Alice Liddell", IsActive: true},
+		{ID: 2, Name: "bob", Email: "bob@domain.com", FullName: "Bob Builder", IsActive: true},
+		{ID: 3, Name: "carol", Email: "carol@domain.com", FullName: "Carol Danvers"},
+	}
+	orgs := []*models.User{{ID: 4, Name: "org"}}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mdl := mock.NewMockModels(ctrl)
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+		Return(users, int64(len(users)), nil).AnyTimes()
+	mdl.EXPECT().
+		SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+		Return(orgs, int64(len(orgs)), nil).AnyTimes()
+	mdl.EXPECT().
+		SearchUsers(gomock.Any()).
+		DoAndReturn(func(opts *models.SearchUserOptions) ([]*models.User, int64, error) {
+			found := []*models.User{}
+			for _, u := range users {
+				if keyword := strings.ToLower(opts.Keyword); strings.Contains(u.Name, keyword) || (opts.SearchByEmail && strings.Contains(u.Email, keyword)) {
+					found = append(found, u)
+				}
+			}
+			return found, int64(len(found)), nil
+		}).AnyTimes()
+	mdl.EXPECT().
+		GetUserByName(gomock.Any()).
+		DoAndReturn(func(name string) (*models.User, error) {
+			for _, u := range users {
+				if strings.EqualFold(u.Name, name) {
+					return u, nil
+				}
+			}
+			return nil, models.ErrUserNotExist{Name: name}
+		}).AnyTimes()
+	mdl.EXPECT().
+		GetUserTeams(gomock.Any(), gomock.Any()).
+		Return([]*models.Team{}, nil).AnyTimes()
+	mdl.EXPECT().
+		SearchTeam(gomock.Any()).
+		Return([]*models.Team{}, int64(0), nil).AnyTimes()
+	h.models = mdl
+
+	data := []struct {
+		scenario string
+		req      ldap.SearchRequest
+
+		dns   []string
+		attrs []string
+		code  ldap.LDAPResultCode
+	}{
+		{
+			scenario: "EqualityIgnoreCase",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(UID=ALICE)"},
+			dns:      []string{h.getUserDN("alice")},
+		},
+		{
+			scenario: "Substrings",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(displayName=*l*d*l)"},
+			dns:      []string{h.getUserDN("alice")},
+		},
+		{
+			scenario: "AndOrNot",
+			req: ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree,
+				Filter: "(&(objectClass=inetOrgPerson)(|(uid=alice)(uid=carol))(!(loginDisabled=true)))"},
+			dns: []string{h.getUserDN("alice")},
+		},
+		{
+			scenario: "Groups",
+			req:      ldap.SearchRequest{BaseDN: "ou=groups,dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(objectClass=*)"},
+			dns:      []string{h.getOrgDN("org")},
+		},
+		{
+			scenario: "BaseScope",
+			req:      ldap.SearchRequest{BaseDN: "uid=bob, ou=users, dc=domain, dc=com", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)"},
+			dns:      []string{h.getUserDN("bob")},
+		},
+		{
+			scenario: "BaseScopeOnParent",
+			req:      ldap.SearchRequest{BaseDN: "ou=users,dc=domain,dc=com", Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)"},
+			dns:      nil,
+		},
+		{
+			scenario: "SingleLevelScope",
+			req:      ldap.SearchRequest{BaseDN: "ou=users,dc=domain,dc=com", Scope: ldap.ScopeSingleLevel, Filter: "(objectClass=*)"},
+			dns:      []string{h.getUserDN("alice"), h.getUserDN("bob"), h.getUserDN("carol")},
+		},
+		{
+			scenario: "SingleLevelScopeOnBaseDN",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeSingleLevel, Filter: "(objectClass=*)"},
+			dns:      nil,
+		},
+		{
+			scenario: "UnknownObjectClass",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(objectClass=person)"},
+			dns:      nil,
+		},
+		{
+			scenario: "Attributes",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(uid=bob)", Attributes: []string{"MAIL", "uid"}},
+			dns:      []string{h.getUserDN("bob")},
+			attrs:    []string{"uid", "mail"},
+		},
+		{
+			scenario: "NoAttributes",
+			req:      ldap.SearchRequest{BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(uid=bob)", Attributes: []string{"1.1"}},
+			dns:      []string{h.getUserDN("bob")},
+			attrs:    []string{},
+		},
+		{
+			scenario: "SizeLimitExceeded",
+			req:      ldap.SearchRequest{BaseDN: "ou=users,dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(uid=*)", SizeLimit: 2},
+			dns:      []string{h.getUserDN("alice"), h.getUserDN("bob")},
+			code:     ldap.LDAPResultSizeLimitExceeded,
+		},
+		{
+			scenario: "SizeLimitNotExceeded",
+			req:      ldap.SearchRequest{BaseDN: "ou=users,dc=domain,dc=com", Scope: ldap.ScopeWholeSubtree, Filter: "(uid=*)", SizeLimit: 3},
+			dns:      []string{h.getUserDN("alice"), h.getUserDN("bob"), h.getUserDN("carol")},
+		},
+	}
+
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			res, err := h.Search(h.getUserDN("admin"), dat.req, nil)
+			require.NoError(t, err)
+			assert.Equal(t, dat.code, res.ResultCode)
+
+			dns := []string(nil)
+			for _, entry := range res.Entries {
+				dns = append(dns, entry.DN)
+				if dat.attrs == nil {
+					continue
+				}
+				attrs := []string{}
+				for _, attr := range entry.Attributes {
+					attrs = append(attrs, attr.Name)
+				}
+				assert.ElementsMatch(t, dat.attrs, attrs)
+			}
+			assert.Equal(t, dat.dns, dns)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package ldaphandler
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nmcclain/ldap"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loadServiceAccounts read htpasswd-like file where each line is 'name:hash'. The hash is either
+// bcrypt or argon2 in its PHC string format
+func loadServiceAccounts(file string) (accounts map[string]string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open service accounts file failed: %w", err)
+	}
+	defer f.Close()
+
+	accounts = map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 1 {
+			return nil, fmt.Errorf("invalid service account at line %d", n)
+		}
+		name, hash := strings.ToLower(line[:i]), line[i+1:]
+		if !isBcrypt(hash) && !isArgon2(hash) {
+			return nil, fmt.Errorf("unsupported password hash of service account '%s'", name)
+		}
+		accounts[name] = hash
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read service accounts file failed: %w", err)
+	}
+	return
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func isArgon2(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$") || strings.HasPrefix(hash, "$argon2i$")
+}
+
+func verifyPassword(hash, pw string) (err error) {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+	case isArgon2(hash):
+		return verifyArgon2(hash, pw)
+	}
+	return fmt.Errorf("unsupported password hash")
+}
+
+// verifyArgon2 verify password against hash in the form of '$argon2id$v=19$m=65536,t=3,p=4$salt$key'
+func verifyArgon2(hash, pw string) (err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return fmt.Errorf("invalid argon2 hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return fmt.Errorf("unsupported argon2 version")
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return fmt.Errorf("invalid argon2 key: %w", err)
+	}
+
+	var actual []byte
+	if parts[1] == "argon2id" {
+		actual = argon2.IDKey([]byte(pw), salt, time, memory, threads, uint32(len(key)))
+	} else {
+		actual = argon2.Key([]byte(pw), salt, time, memory, threads, uint32(len(key)))
+	}
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return fmt.Errorf("password does not match")
+	}
+	return nil
+}
+
+func (h *handler) getServiceDN(name string) string {
+	return fmt.Sprintf("%s=%s,%s,%s", h.userUAttr, name, h.serviceParentRDN, h.baseDN)
+}
+
+// parseServiceDN return name of the service account identified by dn
+func (h *handler) parseServiceDN(dn string) (name string, ok bool) {
+	if name, ok = parseChildDN(dn, h.userUAttr, h.serviceParentRDN, h.baseDN); !ok {
+		return
+	}
+	_, ok = h.services[name]
+	return
+}
+
+// isServiceDN report whether dn is under the services branch, regardless of whether it names a known service account
+func (h *handler) isServiceDN(dn string) bool {
+	_, err := getRDN(normalizeDN(dn), normalizeDN(h.serviceParentRDN.String()), normalizeDN(h.baseDN.String()))
+	return err == nil
+}
+
+// bindService verify the password of the service account. Binding as an unknown service account, or as any other
+// DN under the services branch, fails the same way as an invalid password so that accounts can not be enumerated
+func (h *handler) bindService(bindDN, pw string) (res ldap.LDAPResultCode, err error) {
+	name, ok := h.parseServiceDN(bindDN)
+	if !ok {
+		h.logger.Error("service_sign_in_failed").WithFields("dn", bindDN, "error", "unknown service account")
+		return ldap.LDAPResultInvalidCredentials, nil
+	}
+	if err = verifyPassword(h.services[name], pw); err != nil {
+		h.logger.Error("service_sign_in_failed").WithFields("dn", bindDN, "error", err)
+		return ldap.LDAPResultInvalidCredentials, nil
+	}
+	return ldap.LDAPResultSuccess, nil
+}
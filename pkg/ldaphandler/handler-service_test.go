@@ -0,0 +1,81 @@
+package ldaphandler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func tServiceAccountsFile(t *testing.T, content string) (file string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "ldaphandler")
+	require.NoError(t, err)
+	file = filepath.Join(dir, "services")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestServiceAccounts(t *testing.T) {
+	bhash, err := bcrypt.GenerateFromPassword([]byte("jenkins-secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	salt := []byte("somesalt")
+	key := argon2.IDKey([]byte("grafana-secret"), salt, 1, 64, 1, 32)
+	ahash := fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	file, cleanup := tServiceAccountsFile(t, fmt.Sprintf("# services\njenkins:%s\n\nGrafana:%s\n", bhash, ahash))
+	defer cleanup()
+	h, err := New(WithServiceAccounts(file))
+	require.NoError(t, err)
+
+	data := []struct {
+		scenario string
+		dn       string
+		password string
+		code     ldap.LDAPResultCode
+	}{
+		{scenario: "Bcrypt", dn: h.getServiceDN("jenkins"), password: "jenkins-secret", code: ldap.LDAPResultSuccess},
+		{scenario: "BcryptInvalid", dn: h.getServiceDN("jenkins"), password: "invalid", code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "Argon2", dn: h.getServiceDN("grafana"), password: "grafana-secret", code: ldap.LDAPResultSuccess},
+		{scenario: "Argon2Invalid", dn: h.getServiceDN("grafana"), password: "invalid", code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "CaseInsensitiveDN", dn: "UID=Jenkins, OU=Services, DC=domain, DC=com", password: "jenkins-secret", code: ldap.LDAPResultSuccess},
+		{scenario: "Unknown", dn: h.getServiceDN("unknown"), password: "jenkins-secret", code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "OtherAttribute", dn: "cn=jenkins,ou=services,dc=domain,dc=com", password: "jenkins-secret", code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "Nested", dn: "uid=jenkins,ou=ci,ou=services,dc=domain,dc=com", password: "jenkins-secret", code: ldap.LDAPResultInvalidCredentials},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			code, err := h.Bind(dat.dn, dat.password, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, dat.code, code)
+		})
+	}
+
+	req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeBaseObject, Filter: "(objectClass=*)"}
+	_, err = h.checkSearchPermission(h.getServiceDN("jenkins"), req)
+	assert.NoError(t, err, "service account should be able to search")
+	_, err = h.checkSearchPermission(h.getServiceDN("unknown"), req)
+	assert.Error(t, err, "unknown service account should not be able to search")
+	_, err = h.checkSearchPermission(h.getUserDN("jenkins"), req)
+	assert.Error(t, err, "service account is not a gitea user")
+}
+
+func TestServiceAccountsInvalidFile(t *testing.T) {
+	_, err := New(WithServiceAccounts(filepath.Join("testdata", "notexist")))
+	assert.Error(t, err, "should return error when file not exist")
+
+	for _, content := range []string{"jenkins", "jenkins:plaintext", ":$2a$10$abc"} {
+		file, cleanup := tServiceAccountsFile(t, content)
+		_, err = New(WithServiceAccounts(file))
+		assert.Error(t, err, content)
+		cleanup()
+	}
+}
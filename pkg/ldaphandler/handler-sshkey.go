@@ -0,0 +1,26 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"strings"
+
+	"code.gitea.io/gitea/models"
+)
+
+// listSSHPublicKeys return the user's ssh public keys, or nothing when they are not published
+func (h *handler) listSSHPublicKeys(user *models.User) (keys []string, err error) {
+	if !h.sshPublicKeys {
+		return
+	}
+	pks, err := h.models.ListPublicKeys(user.ID, models.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list user's public keys failed: %w", err)
+	}
+	for _, pk := range pks {
+		if h.excludeDeployKeys && pk.Type == models.KeyTypeDeploy {
+			continue
+		}
+		keys = append(keys, strings.TrimSpace(pk.Content))
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package ldaphandler
+
+import (
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchSSHPublicKeys(t *testing.T) {
+	alice := &models.User{ID: 1, Name: "alice", IsActive: true}
+	bob := &models.User{ID: 2, Name: "bob", IsActive: true}
+	keys := map[int64][]*models.PublicKey{
+		alice.ID: {
+			{OwnerID: alice.ID, Type: models.KeyTypeUser, Content: "ssh-ed25519 AAAA alice@laptop\n"},
+			{OwnerID: alice.ID, Type: models.KeyTypeDeploy, Content: "ssh-rsa BBBB deploy"},
+		},
+	}
+
+	data := []struct {
+		scenario string
+		exclude  bool
+		expected []string
+	}{
+		{scenario: "WithDeployKeys", expected: []string{"ssh-ed25519 AAAA alice@laptop", "ssh-rsa BBBB deploy"}},
+		{scenario: "WithoutDeployKeys", exclude: true, expected: []string{"ssh-ed25519 AAAA alice@laptop"}},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			h, err := New(WithSSHPublicKeys(dat.exclude))
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mdl := mock.NewMockModels(ctrl)
+			mdl.EXPECT().
+				SearchUsers(reflectEq{&models.SearchUserOptions{}}).
+				Return([]*models.User{alice, bob}, int64(2), nil)
+			mdl.EXPECT().
+				SearchUsers(reflectEq{&models.SearchUserOptions{Type: models.UserTypeOrganization}}).
+				Return([]*models.User{}, int64(0), nil)
+			mdl.EXPECT().
+				GetUserTeams(gomock.Any(), gomock.Any()).
+				Return([]*models.Team{}, nil).Times(2)
+			for _, user := range []*models.User{alice, bob} {
+				mdl.EXPECT().
+					ListPublicKeys(user.ID, reflectEq{models.ListOptions{}}).
+					Return(keys[user.ID], nil)
+			}
+			h.models = mdl
+
+			req := ldap.SearchRequest{BaseDN: h.baseDN.String(), Scope: ldap.ScopeWholeSubtree,
+				Filter: "(uid=*)", Attributes: []string{"sshPublicKey", "objectClass"}}
+			res, err := h.Search(h.getUserDN("admin"), req, nil)
+			require.NoError(t, err)
+			require.Len(t, res.Entries, 2)
+			assert.Equal(t, []*ldap.EntryAttribute{
+				{Name: "sshPublicKey", Values: dat.expected},
+				{Name: "objectClass", Values: []string{"inetorgperson", "ldappublickey"}},
+			}, res.Entries[0].Attributes)
+			assert.Equal(t, []*ldap.EntryAttribute{
+				{Name: "objectClass", Values: []string{"inetorgperson"}},
+			}, res.Entries[1].Attributes, "user without key should not be ldapPublicKey")
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package ldaphandler
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"code.gitea.io/gitea/models"
+)
+
+// isAccessToken check whether the password has the form of gitea access token, a 40 characters hex string
+func isAccessToken(pw string) bool {
+	if len(pw) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(pw)
+	return err == nil
+}
+
+// checkAccessToken verify that token is an access token owned by the user
+func (h *handler) checkAccessToken(uname, token string) error {
+	if !isAccessToken(token) {
+		return fmt.Errorf("password is not an access token")
+	}
+	t, err := h.models.GetAccessTokenBySHA(token)
+	if models.IsErrAccessTokenNotExist(err) || models.IsErrAccessTokenEmpty(err) {
+		return fmt.Errorf("access token does not exist") // the original error contains the token
+	}
+	if err != nil {
+		return fmt.Errorf("get access token failed: %w", err)
+	}
+
+	u, err := h.models.GetUserByName(uname)
+	if err != nil {
+		return fmt.Errorf("get user failed: %w", err)
+	}
+	if t.UID != u.ID {
+		return fmt.Errorf("access token does not belong to the user")
+	}
+	if !u.IsActive || u.ProhibitLogin {
+		return fmt.Errorf("user is not allowed to sign in")
+	}
+	return nil
+}
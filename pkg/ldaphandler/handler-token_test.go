@@ -0,0 +1,75 @@
+package ldaphandler
+
+import (
+	"fmt"
+	"testing"
+
+	"code.gitea.io/gitea/models"
+	"github.com/golang/mock/gomock"
+	"github.com/nmcclain/ldap"
+	"github.com/rucciva/giteaty/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindToken(t *testing.T) {
+	const token = "0123456789abcdef0123456789abcdef01234567"
+	user := &models.User{ID: 1, Name: "rucciva", IsActive: true}
+
+	data := []struct {
+		scenario  string
+		tokenOnly bool
+		password  string
+
+		signIn    bool
+		owner     int64
+		tokenErr  error
+		inactive  bool
+		lookToken bool
+
+		code ldap.LDAPResultCode
+	}{
+		{scenario: "Password", password: "password", signIn: true, code: ldap.LDAPResultSuccess},
+		{scenario: "Token", password: token, owner: user.ID, lookToken: true, code: ldap.LDAPResultSuccess},
+		{scenario: "TokenOfOtherUser", password: token, owner: 2, lookToken: true, code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "UnknownToken", password: token, tokenErr: models.ErrAccessTokenNotExist{Token: token}, lookToken: true,
+			code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "InactiveUser", password: token, owner: user.ID, inactive: true, lookToken: true, code: ldap.LDAPResultInvalidCredentials},
+		{scenario: "TokenOnly", tokenOnly: true, password: token, owner: user.ID, lookToken: true, code: ldap.LDAPResultSuccess},
+		{scenario: "TokenOnlyRejectPassword", tokenOnly: true, password: "password", code: ldap.LDAPResultInvalidCredentials},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			h, err := New(WithTokenOnly(dat.tokenOnly))
+			require.NoError(t, err)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mdl := mock.NewMockModels(ctrl)
+			if !dat.tokenOnly {
+				signInErr := fmt.Errorf("invalid password")
+				if dat.signIn {
+					signInErr = nil
+				}
+				mdl.EXPECT().UserSignIn(user.Name, dat.password).Return(user, signInErr)
+			}
+			if dat.lookToken {
+				var tok *models.AccessToken
+				if dat.tokenErr == nil {
+					tok = &models.AccessToken{UID: dat.owner}
+				}
+				mdl.EXPECT().GetAccessTokenBySHA(dat.password).Return(tok, dat.tokenErr)
+			}
+			if dat.lookToken && dat.tokenErr == nil {
+				u := *user
+				u.IsActive = !dat.inactive
+				mdl.EXPECT().GetUserByName(user.Name).Return(&u, nil)
+			}
+			h.models = mdl
+
+			code, err := h.Bind(h.getUserDN(user.Name), dat.password, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, dat.code, code)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package ldaphandler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxWebhookPayload limits the size of webhook request body
+const maxWebhookPayload = 1 << 20
+
+// webhookAccount is a user or an organization in webhook payloads
+type webhookAccount struct {
+	Login string `json:"login"`
+}
+
+// webhookPayload holds the fields of webhook payloads naming the changed users and groups. Gitea 1.12 only sends
+// repository events, the user, organization, team, and membership events are expected from another emitter,
+// e.g. a script run by an administrator, with payloads shaped like Gitea's:
+//
+//	{"action": "edited", "user": {"login": "alice"}}
+//	{"action": "edited", "organization": {"login": "org"}}
+//	{"action": "edited", "organization": {"login": "org"}, "team": {"name": "owners"}}
+//	{"action": "removed", "organization": {"login": "org"}, "team": {"name": "owners"}, "member": {"login": "alice"}}
+//	{"action": "edited", "organization": {"login": "org"}, "team": {"name": "admins"}, "changes": {"name": {"from": "owners"}}}
+type webhookPayload struct {
+	Action       string                     `json:"action"`
+	Changes      map[string]json.RawMessage `json:"changes"`
+	User         *webhookAccount            `json:"user"`
+	Member       *webhookAccount            `json:"member"`
+	Organization *webhookAccount            `json:"organization"`
+	Team         *struct {
+		Name string `json:"name"`
+	} `json:"team"`
+}
+
+// structuralActions add or remove entries, which is only reflected by listing the directory
+var structuralActions = map[string]bool{
+	"created": true,
+	"deleted": true,
+	"renamed": true,
+}
+
+// renamingChanges are the changed fields naming users or groups. Changing them moves the entry to another DN,
+// which is structural whatever the action, e.g. an edited team whose name changed
+var renamingChanges = []string{"login", "name", "username"}
+
+// isStructural tell whether the payload adds, removes, or renames entries
+func (p *webhookPayload) isStructural() bool {
+	if structuralActions[p.Action] {
+		return true
+	}
+	for _, field := range renamingChanges {
+		if _, ok := p.Changes[field]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedEntries return the DNs of entries that may be changed by the webhook event, or all when the whole
+// directory may change, e.g. when the payload does not name the changed user or group
+func (h *handler) affectedEntries(event string, payload []byte) (dns []string, all bool) {
+	var p webhookPayload
+	switch event {
+	case "repository":
+		return nil, h.repoGroups
+	case "user", "organization", "team", "member", "membership":
+		if json.Unmarshal(payload, &p) != nil || p.isStructural() {
+			return nil, true
+		}
+	default:
+		return nil, false
+	}
+
+	org := ""
+	if p.Organization != nil {
+		org = p.Organization.Login
+	}
+	switch event {
+	case "user":
+		if p.User == nil || p.User.Login == "" {
+			return nil, true
+		}
+		dns = append(dns, h.getUserDN(p.User.Login))
+		if h.adminGroup != "" {
+			dns = append(dns, h.getAdminGroupDN()) // the user may have been made administrator
+		}
+		return dns, false
+
+	case "organization":
+		if org == "" {
+			return nil, true
+		}
+		return []string{h.getOrgDN(org)}, false
+
+	case "team":
+		if org == "" || p.Team == nil || p.Team.Name == "" || h.repoGroups {
+			return nil, true // the team's access to repositories may have changed
+		}
+		return []string{h.getTeamDN(org, p.Team.Name)}, false
+	}
+
+	if org == "" || p.Member == nil || p.Member.Login == "" || h.repoGroups {
+		return nil, true
+	}
+	dns = append(dns, h.getUserDN(p.Member.Login), h.getOrgDN(org))
+	if p.Team != nil && p.Team.Name != "" {
+		dns = append(dns, h.getTeamDN(org, p.Team.Name))
+	}
+	return dns, false
+}
+
+// verifyWebhookSignature check the hex encoded HMAC-SHA256 of the payload sent by gitea
+func verifyWebhookSignature(secret, signature string, payload []byte) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// ServeHTTP accept gitea webhooks signed using the secret set by WithWebhookSecret and evict the cached entries
+// the event may change, or invalidate the cached directory when it can not tell
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.webhookSecret == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		h.logger.Warn("read_webhook_failed").WithFields("error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !verifyWebhookSignature(h.webhookSecret, r.Header.Get("X-Gitea-Signature"), payload) {
+		h.logger.Warn("invalid_webhook_signature").WithFields("remote_addr", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	dns, all := h.affectedEntries(r.Header.Get("X-Gitea-Event"), payload)
+	if all {
+		h.invalidateDirectory()
+	} else if len(dns) > 0 {
+		h.evictEntries(dns...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package ldaphandler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithWebhookSecretEmpty(t *testing.T) {
+	_, err := New(WithWebhookSecret(""))
+	assert.Error(t, err, "should reject empty secret")
+}
+
+func TestServeWebhook(t *testing.T) {
+	h, err := New(WithCache(1024*1024, 60), WithWebhookSecret("secret"))
+	require.NoError(t, err)
+
+	sign := func(secret string, payload []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(payload)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	membership := []byte(`{"action":"removed","organization":{"login":"org"},"team":{"name":"owners"},"member":{"login":"alice"}}`)
+	user := []byte(`{"action":"edited","user":{"login":"alice"}}`)
+	created := []byte(`{"action":"created","user":{"login":"alice"}}`)
+	org := []byte(`{"action":"edited","organization":{"login":"org"}}`)
+	team := []byte(`{"action":"edited","organization":{"login":"org"},"team":{"name":"owners"}}`)
+	teamRenamed := []byte(`{"action":"edited","organization":{"login":"org"},"team":{"name":"owners"},"changes":{"name":{"from":"admins"}}}`)
+	userRenamed := []byte(`{"action":"edited","user":{"login":"alice"},"changes":{"login":{"from":"alicia"}}}`)
+	incomplete := []byte(`{"action":"removed"}`)
+	entries := []string{h.getUserDN("alice"), h.getOrgDN("org"), h.getTeamDN("org", "owners")}
+
+	data := []struct {
+		scenario    string
+		method      string
+		event       string
+		payload     []byte
+		signature   string
+		repoGroups  bool
+		code        int
+		invalidated bool
+		evicted     []string
+	}{
+		{scenario: "Membership", method: http.MethodPost, event: "membership", payload: membership, signature: sign("secret", membership), code: http.StatusNoContent, evicted: entries},
+		{scenario: "MembershipWithRepoGroups", method: http.MethodPost, event: "membership", payload: membership, signature: sign("secret", membership), repoGroups: true, code: http.StatusNoContent, invalidated: true},
+		{scenario: "MembershipWithoutMember", method: http.MethodPost, event: "membership", payload: incomplete, signature: sign("secret", incomplete), code: http.StatusNoContent, invalidated: true},
+		{scenario: "User", method: http.MethodPost, event: "user", payload: user, signature: sign("secret", user), code: http.StatusNoContent, evicted: entries[:1]},
+		{scenario: "UserCreated", method: http.MethodPost, event: "user", payload: created, signature: sign("secret", created), code: http.StatusNoContent, invalidated: true},
+		{scenario: "Organization", method: http.MethodPost, event: "organization", payload: org, signature: sign("secret", org), code: http.StatusNoContent, evicted: entries[1:2]},
+		{scenario: "Team", method: http.MethodPost, event: "team", payload: team, signature: sign("secret", team), code: http.StatusNoContent, evicted: entries[2:]},
+		{scenario: "TeamRenamed", method: http.MethodPost, event: "team", payload: teamRenamed, signature: sign("secret", teamRenamed), code: http.StatusNoContent, invalidated: true},
+		{scenario: "UserRenamed", method: http.MethodPost, event: "user", payload: userRenamed, signature: sign("secret", userRenamed), code: http.StatusNoContent, invalidated: true},
+		{scenario: "Push", method: http.MethodPost, event: "push", payload: user, signature: sign("secret", user), code: http.StatusNoContent},
+		{scenario: "RepositoryWithoutRepoGroups", method: http.MethodPost, event: "repository", payload: created, signature: sign("secret", created), code: http.StatusNoContent},
+		{scenario: "RepositoryWithRepoGroups", method: http.MethodPost, event: "repository", payload: created, signature: sign("secret", created), repoGroups: true, code: http.StatusNoContent, invalidated: true},
+		{scenario: "InvalidSignature", method: http.MethodPost, event: "team", payload: team, signature: sign("invalid", team), code: http.StatusUnauthorized},
+		{scenario: "MissingSignature", method: http.MethodPost, event: "team", payload: team, code: http.StatusUnauthorized},
+		{scenario: "Get", method: http.MethodGet, event: "team", payload: team, signature: sign("secret", team), code: http.StatusMethodNotAllowed},
+	}
+	for _, dat := range data {
+		t.Run(dat.scenario, func(t *testing.T) {
+			h.repoGroups = dat.repoGroups
+			h.setCachedDirectory(directory{
+				Users:  []*ldap.Entry{{DN: entries[0]}},
+				Groups: []*ldap.Entry{{DN: entries[1]}, {DN: entries[2]}},
+			})
+
+			req := httptest.NewRequest(dat.method, "/", bytes.NewReader(dat.payload))
+			req.Header.Set("X-Gitea-Event", dat.event)
+			req.Header.Set("X-Gitea-Signature", dat.signature)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, dat.code, rec.Code)
+			assert.Equal(t, dat.invalidated, !h.getCached(keyDirectory, &cachedIndex{}))
+			evicted := map[string]bool{}
+			for _, dn := range dat.evicted {
+				evicted[dn] = true
+			}
+			for _, dn := range entries {
+				assert.Equal(t, evicted[dn], !h.getCached(keyEntry(dn), &ldap.Entry{}), dn)
+			}
+		})
+	}
+}
@@ -1,17 +1,20 @@
 package ldaphandler
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"code.gitea.io/gitea/models"
 	"git.rucciva.one/rucciva/log"
 	"github.com/coocood/freecache"
 	"github.com/rucciva/giteaty/pkg/gitea"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/nmcclain/ldap"
 )
@@ -29,6 +32,75 @@ func WithBaseDN(baseDN string) option {
 	}
 }
 
+// WithProfile select the schema used to render users and groups, either inetorgperson or ad.
+// The ad profile names users by cn, like Active Directory does
+func WithProfile(profile string) option {
+	return func(h *handler) (err error) {
+		switch profile {
+		case profileInetOrgPerson:
+		case profileAD:
+			h.userUAttr = "cn"
+		default:
+			return fmt.Errorf("unknown profile %s", profile)
+		}
+		h.profile = profile
+		return
+	}
+}
+
+// WithUserUniqueAttribute set the attribute used as RDN of users
+func WithUserUniqueAttribute(attr string) option {
+	return func(h *handler) (err error) {
+		h.userUAttr = attr
+		return
+	}
+}
+
+// WithGroupUniqueAttribute set the attribute used as RDN of groups
+func WithGroupUniqueAttribute(attr string) option {
+	return func(h *handler) (err error) {
+		h.groupUAttr = attr
+		return
+	}
+}
+
+// WithAttributeMapping rename, copy, and add attributes of users and groups as defined in the JSON file
+func WithAttributeMapping(file string) option {
+	return func(h *handler) (err error) {
+		h.mappings, err = loadAttributeMappings(file)
+		return
+	}
+}
+
+// WithRepoGroups add one group per repository under ou=repos whose members are users with read access to it.
+// When perAccessLevel is true, there is one group per repository and access level instead. Repository groups
+// are not listed in users' memberOf
+func WithRepoGroups(perAccessLevel bool) option {
+	return func(h *handler) (err error) {
+		h.repoGroups = true
+		h.repoGroupsPerAccessLevel = perAccessLevel
+		return
+	}
+}
+
+// WithAdminGroup add a group named name under ou=groups whose members are gitea site administrators.
+// New fails when an organization already has the name
+func WithAdminGroup(name string) option {
+	return func(h *handler) (err error) {
+		h.adminGroup = name
+		return
+	}
+}
+
+// WithUserFlags add gitea's isAdmin, isRestricted, and prohibitLogin attributes to users. Visibility is not
+// added since only public users are listed
+func WithUserFlags() option {
+	return func(h *handler) (err error) {
+		h.userFlags = true
+		return
+	}
+}
+
 func WithSearchers(usernames []string) option {
 	return func(h *handler) (err error) {
 		for _, u := range usernames {
@@ -46,6 +118,147 @@ func WithCache(size, expireSecond int) option {
 	}
 }
 
+// WithCacheBackend cache the directory in c, e.g. a cache shared by multiple replicas. Invalidating the
+// directory, e.g. by webhook, removes it from c and therefore from every replica. Remote caches should implement
+// BatchCache, so that the directory is read in a single round trip
+func WithCacheBackend(c Cache, expireSecond int) option {
+	return func(h *handler) (err error) {
+		h.cache = c
+		h.cacheExpire = expireSecond
+		return
+	}
+}
+
+// WithCacheStale keep serving the cached directory for staleSecond after it expires while it is refreshed
+// in the background
+func WithCacheStale(staleSecond int) option {
+	return func(h *handler) (err error) {
+		h.cacheStale = staleSecond
+		return
+	}
+}
+
+// WithCacheRefresh refresh the cached directory in the background every intervalSecond until ctx is done.
+// Interval shorter than the cache expiry prevents searches from waiting for the directory to be listed.
+// Refreshing starts once New succeeds
+func WithCacheRefresh(ctx context.Context, intervalSecond int) option {
+	return func(h *handler) (err error) {
+		if intervalSecond <= 0 {
+			return fmt.Errorf("cache refresh interval must be positive")
+		}
+		h.cacheRefresh = true
+		h.cacheRefreshCtx = ctx
+		h.cacheRefreshInterval = time.Duration(intervalSecond) * time.Second
+		return
+	}
+}
+
+// WithWebhookSecret accept gitea webhooks signed using the secret to invalidate cached directory
+func WithWebhookSecret(secret string) option {
+	return func(h *handler) (err error) {
+		if secret == "" {
+			return fmt.Errorf("webhook secret must not be empty")
+		}
+		h.webhookSecret = secret
+		return
+	}
+}
+
+// WithPosix add posixAccount and posixGroup attributes. Users and organizations numeric IDs are their gitea ID
+// added by idOffset, while teams' are their gitea ID added by teamIDOffset. Every user also has a private group
+// whose gidNumber is the user's uidNumber. homeDirectory and loginShell are text/template executed against the gitea user
+func WithPosix(idOffset, teamIDOffset int64, homeDirectory, loginShell string) option {
+	return func(h *handler) (err error) {
+		h.posix = true
+		h.posixIDOffset, h.posixTeamIDOffset = idOffset, teamIDOffset
+		if h.homeDirectory, err = template.New("homeDirectory").Parse(homeDirectory); err != nil {
+			return fmt.Errorf("invalid home directory template: %w", err)
+		}
+		if h.loginShell, err = template.New("loginShell").Parse(loginShell); err != nil {
+			return fmt.Errorf("invalid login shell template: %w", err)
+		}
+		for _, tmpl := range []*template.Template{h.homeDirectory, h.loginShell} {
+			if _, err = executeTemplate(tmpl, &models.User{}); err != nil {
+				return fmt.Errorf("invalid %s template: %w", tmpl.Name(), err)
+			}
+		}
+		return
+	}
+}
+
+// WithSSHPublicKeys add users' ssh public keys as sshPublicKey attribute. Deploy keys are added as well,
+// unless excludeDeployKeys is true
+func WithSSHPublicKeys(excludeDeployKeys bool) option {
+	return func(h *handler) (err error) {
+		h.sshPublicKeys = true
+		h.excludeDeployKeys = excludeDeployKeys
+		return
+	}
+}
+
+// WithServiceAccounts allow accounts listed in the file to bind under the services branch and search.
+// They are not gitea users and never appear in search results
+func WithServiceAccounts(file string) option {
+	return func(h *handler) (err error) {
+		h.services, err = loadServiceAccounts(file)
+		return
+	}
+}
+
+// WithACL restrict what bound identities may read using rules defined in the JSON file
+func WithACL(file string) option {
+	return func(h *handler) (err error) {
+		h.acl, err = loadACL(file)
+		return
+	}
+}
+
+// WithSelfSearch allow bound gitea users to do base scope search on their own entry and, when groups is true,
+// on the entries of their groups
+func WithSelfSearch(groups bool) option {
+	return func(h *handler) (err error) {
+		h.selfSearch = true
+		h.selfSearchGroups = groups
+		return
+	}
+}
+
+// WithPasswordModify allow bound gitea users to change their password using password modify extended operation
+func WithPasswordModify() option {
+	return func(h *handler) (err error) {
+		h.passwordModify = true
+		return
+	}
+}
+
+// WithTokenOnly only accept gitea access token as bind password. Otherwise, access token is accepted
+// when the password does not match
+func WithTokenOnly(tokenOnly bool) option {
+	return func(h *handler) (err error) {
+		h.tokenOnly = tokenOnly
+		return
+	}
+}
+
+// WithOTP accept gitea two-factor passcode or scratch token either appended to the password
+// or to the username as 'username;otp'. When required is true, password-only bind of users enrolled
+// in two-factor authentication is refused
+func WithOTP(required bool) option {
+	return func(h *handler) (err error) {
+		h.otp = true
+		h.requireOTP = required
+		return
+	}
+}
+
+// WithSupportedExtensions set OIDs of extended operations advertised in the root DSE
+func WithSupportedExtensions(oids []string) option {
+	return func(h *handler) (err error) {
+		h.extensions = append(h.extensions, oids...)
+		return
+	}
+}
+
 func WithModels(m gitea.Models) option {
 	return func(h *handler) (err error) {
 		h.models = m
@@ -61,7 +274,8 @@ func WithLogger(l log.PLogger) option {
 }
 
 type handler struct {
-	baseDN names
+	baseDN  names
+	profile string
 
 	userParentRDN names
 	userUAttr     string
@@ -69,30 +283,82 @@ type handler struct {
 	groupParentRDN names
 	groupUAttr     string
 
+	adminGroup string
+	userFlags  bool
+
+	repoParentRDN            names
+	repoGroups               bool
+	repoGroupsPerAccessLevel bool
+
+	serviceParentRDN names
+	services         map[string]string
+
+	mappings *attributeMappings
+
 	searchers map[string]bool
+	acl       []*aclRule
+	tokenOnly bool
+
+	selfSearch       bool
+	selfSearchGroups bool
+
+	passwordModify bool
+
+	otp        bool
+	requireOTP bool
+
+	extensions []string
+
+	posix             bool
+	posixIDOffset     int64
+	posixTeamIDOffset int64
+	homeDirectory     *template.Template
+	loginShell        *template.Template
 
-	cache       *freecache.Cache
-	cacheExpire int
+	sshPublicKeys     bool
+	excludeDeployKeys bool
+
+	cache                Cache
+	cacheExpire          int
+	cacheStale           int
+	cacheRefresh         bool
+	cacheRefreshCtx      context.Context
+	cacheRefreshInterval time.Duration
+	refreshGroup         singleflight.Group
+
+	webhookSecret string
 
 	models gitea.Models
 
+	pagesMu    sync.Mutex
+	pages      map[string]map[string]*pagedSearch
+	pagesSaved uint64
+
 	logger log.PLogger
 }
 
-var keyUsers = []byte("users")
-
 // New return ldap's Binder, Searcher, & Closer
 func New(opts ...option) (h *handler, err error) {
 	h = &handler{
 		baseDN:        newNames("dc=domain,dc=com"),
+		profile:       profileInetOrgPerson,
 		userParentRDN: newNames("ou=users"),
 		userUAttr:     "uid",
 
 		groupParentRDN: newNames("ou=groups"),
 		groupUAttr:     "cn",
 
+		repoParentRDN: newNames("ou=repos"),
+
+		serviceParentRDN: newNames("ou=services"),
+		services:         map[string]string{},
+
 		searchers: map[string]bool{"admin": true},
 
+		extensions: []string{},
+
+		pages: map[string]map[string]*pagedSearch{},
+
 		logger: log.GetPGlobal(),
 	}
 	for _, opt := range opts {
@@ -100,10 +366,26 @@ func New(opts ...option) (h *handler, err error) {
 			return
 		}
 	}
+	if h.mappings != nil {
+		if err = h.mappings.Users.validate(h.userUAttr); err != nil {
+			return
+		}
+		if err = h.mappings.Groups.validate(h.groupUAttr); err != nil {
+			return
+		}
+	}
+	if h.adminGroup != "" && h.models != nil {
+		if err = h.checkAdminGroup(); err != nil {
+			return
+		}
+	}
 	for u := range h.searchers {
 		delete(h.searchers, u)
 		h.searchers[strings.ToLower(h.getUserDN(u))] = true
 	}
+	if h.cacheRefresh {
+		go h.refreshDirectoryPeriodically(h.cacheRefreshCtx, h.cacheRefreshInterval)
+	}
 	return
 }
 
@@ -117,6 +399,10 @@ func getRDN(DN string, parentsRDN ...string) (rDN string, err error) {
 }
 
 func (h *handler) Bind(bindDN, pw string, conn net.Conn) (res ldap.LDAPResultCode, err error) {
+	if h.isServiceDN(bindDN) {
+		return h.bindService(bindDN, pw)
+	}
+
 	rdn, err := getRDN(bindDN, h.userParentRDN.String(), h.baseDN.String())
 	if err != nil {
 		h.logger.Error("invalid_bind_dn").WithFields("dn", bindDN, "error", err)
@@ -133,8 +419,23 @@ func (h *handler) Bind(bindDN, pw string, conn net.Conn) (res ldap.LDAPResultCod
 		return ldap.LDAPResultInvalidDNSyntax, nil
 	}
 
-	if _, err = h.models.UserSignIn(uname, pw); err != nil {
+	otp := ""
+	if h.otp {
+		uname, otp = splitUsernameOTP(uname)
+	}
+
+	if !h.tokenOnly {
+		if err = h.signIn(uname, pw, otp); err == nil {
+			return ldap.LDAPResultSuccess, nil
+		}
 		h.logger.Error("gitea_sign_in_failed").WithFields("dn", bindDN, "error", err)
+		if !isAccessToken(pw) {
+			return ldap.LDAPResultInvalidCredentials, nil
+		}
+	}
+
+	if err = h.checkAccessToken(uname, pw); err != nil {
+		h.logger.Error("gitea_token_sign_in_failed").WithFields("dn", bindDN, "error", err)
 		return ldap.LDAPResultInvalidCredentials, nil
 	}
 	return ldap.LDAPResultSuccess, nil
@@ -152,17 +453,23 @@ func (h *handler) getOrgDN(org string) string {
 	return fmt.Sprintf("%s=%s,%s,%s", h.groupUAttr, org, h.groupParentRDN, h.baseDN)
 }
 
-func (h *handler) checkSearchPermission(boundDN string, searchReq ldap.SearchRequest) error {
+func (h *handler) checkSearchPermission(boundDN string, searchReq ldap.SearchRequest) (acc *access, err error) {
 	if len(boundDN) < 1 {
-		return fmt.Errorf("Search Error: Anonymous BindDN not allowed")
+		return nil, fmt.Errorf("Search Error: Anonymous BindDN not allowed")
 	}
-	if !strings.HasSuffix(strings.ToLower(searchReq.BaseDN), h.baseDN.String()) {
-		return fmt.Errorf("Search Error: search BaseDN %s is not in our BaseDN %s", searchReq.BaseDN, h.baseDN)
+	if !strings.HasSuffix(normalizeDN(searchReq.BaseDN), normalizeDN(h.baseDN.String())) {
+		return nil, fmt.Errorf("Search Error: search BaseDN %s is not in our BaseDN %s", searchReq.BaseDN, h.baseDN)
 	}
-	if !h.searchers[strings.ToLower(boundDN)] {
-		return fmt.Errorf("Search Error: BindDN '%s' is not permitted to search", boundDN)
+	if acc, err = h.getAccess(boundDN); err != nil {
+		return nil, fmt.Errorf("Search Error: get access of BindDN '%s' failed: %w", boundDN, err)
 	}
-	return nil
+	if acc == nil {
+		return nil, fmt.Errorf("Search Error: BindDN '%s' is not permitted to search", boundDN)
+	}
+	if acc.bases != nil && (searchReq.Scope != ldap.ScopeBaseObject || !acc.bases[normalizeDN(searchReq.BaseDN)]) {
+		return nil, fmt.Errorf("Search Error: BindDN '%s' is only permitted to do base scope search on its own entry", boundDN)
+	}
+	return
 }
 
 func (h *handler) getMemberOf(orgByID map[int64]*models.User, teams []*models.Team) (memberOf []string) {
@@ -182,110 +489,172 @@ func (h *handler) getMemberOf(orgByID map[int64]*models.User, teams []*models.Te
 	return
 }
 
-func (h *handler) listUsers() (entries []*ldap.Entry, err error) {
+func (h *handler) newUserEntry(user *models.User, orgByID map[int64]*models.User, teams []*models.Team, keys []string) *ldap.Entry {
+	attrs := []*ldap.EntryAttribute{}
+	attrs = append(attrs, &ldap.EntryAttribute{Name: h.userUAttr, Values: []string{user.Name}})
+	attrs = append(attrs, &ldap.EntryAttribute{Name: "displayName", Values: []string{user.FullName}})
+	if !user.KeepEmailPrivate {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "mail", Values: []string{user.Email}})
+	}
+	objectClass := []string{"inetorgperson"}
+	if h.profile == profileAD {
+		attrs = append(attrs, h.newADUserAttributes(user)...)
+		objectClass = []string{"top", "person", "organizationalperson", "user"}
+	} else {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "loginDisabled", Values: []string{strconv.FormatBool(!user.IsActive)}})
+	}
+	memberOf := h.getMemberOf(orgByID, teams)
+	if h.adminGroup != "" && user.IsAdmin {
+		memberOf = append(memberOf, h.getAdminGroupDN())
+	}
+	if len(memberOf) > 0 {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "memberOf", Values: memberOf})
+	}
+	if h.userFlags {
+		attrs = append(attrs, newUserFlagAttributes(user)...)
+		objectClass = append(objectClass, "giteauser")
+	}
+	if h.posix {
+		attrs = append(attrs, h.newPosixAttributes(user)...)
+		objectClass = append(objectClass, "posixaccount")
+	}
+	if len(keys) > 0 {
+		attrs = append(attrs, &ldap.EntryAttribute{Name: "sshPublicKey", Values: keys})
+		objectClass = append(objectClass, "ldappublickey")
+	}
+	if h.mappings != nil {
+		attrs = h.applyMapping(&h.mappings.Users, attrs, user)
+	}
+	attrs = append(attrs, &ldap.EntryAttribute{Name: "objectClass", Values: objectClass})
+	attrs = append(attrs, h.userParentRDN.Attributes()...)
+	attrs = append(attrs, h.baseDN.Attributes()...)
+	attrs = append(attrs, newOperationalAttributes("user", user.ID, user.CreatedUnix, user.UpdatedUnix)...)
+
+	return &ldap.Entry{DN: h.getUserDN(user.Name), Attributes: attrs}
+}
+
+type directory struct {
+	Users  []*ldap.Entry
+	Groups []*ldap.Entry
+}
+
+func (h *handler) listDirectory() (dir directory, err error) {
 	users, _, err := h.models.SearchUsers(&models.SearchUserOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("search gitea users failed: %w", err)
+		return dir, fmt.Errorf("search gitea users failed: %w", err)
 	}
 
 	orgs, _, err := h.models.SearchUsers(&models.SearchUserOptions{Type: models.UserTypeOrganization})
 	if err != nil {
-		return nil, fmt.Errorf("search gitea organizations failed: %w", err)
+		return dir, fmt.Errorf("search gitea organizations failed: %w", err)
 	}
 	orgByID := map[int64]*models.User{}
 	for _, org := range orgs {
 		orgByID[org.ID] = org
 	}
 
+	members := newGroupMembers()
 	for _, user := range users {
 		teams, err := h.models.GetUserTeams(user.ID, models.ListOptions{})
 		if err != nil {
-			return nil, fmt.Errorf("get user's teams failed: %w", err)
+			return dir, fmt.Errorf("get user's teams failed: %w", err)
 		}
+		keys, err := h.listSSHPublicKeys(user)
+		if err != nil {
+			return dir, err
+		}
+
+		entry := h.newUserEntry(user, orgByID, teams, keys)
+		dir.Users = append(dir.Users, entry)
+		members.add(user, entry.DN, teams)
+	}
 
-		dn := h.getUserDN(user.Name)
-		attrs := []*ldap.EntryAttribute{}
-		attrs = append(attrs, &ldap.EntryAttribute{Name: h.userUAttr, Values: []string{user.Name}})
-		attrs = append(attrs, &ldap.EntryAttribute{Name: "displayName", Values: []string{user.FullName}})
-		if !user.KeepEmailPrivate {
-			attrs = append(attrs, &ldap.EntryAttribute{Name: "mail", Values: []string{user.Email}})
+	if dir.Groups, err = h.listGroups(orgs, members); err != nil {
+		return
+	}
+	if h.adminGroup != "" {
+		dir.Groups = append(dir.Groups, h.newAdminGroupEntry(members))
+	}
+	if h.posix {
+		for _, user := range users {
+			dir.Groups = append(dir.Groups, h.newPrivateGroupEntry(user, members.byUser[user.ID]))
 		}
-		attrs = append(attrs, &ldap.EntryAttribute{Name: "loginDisabled", Values: []string{strconv.FormatBool(!user.IsActive)}})
-		if memberOf := h.getMemberOf(orgByID, teams); len(memberOf) > 0 {
-			attrs = append(attrs, &ldap.EntryAttribute{Name: "memberOf", Values: memberOf})
+	}
+	if h.repoGroups {
+		repos, err := h.listRepoGroups(members)
+		if err != nil {
+			return dir, err
 		}
-		attrs = append(attrs, &ldap.EntryAttribute{Name: "objectClass", Values: []string{"inetorgperson"}})
-		attrs = append(attrs, h.userParentRDN.Attributes()...)
-		attrs = append(attrs, h.baseDN.Attributes()...)
-
-		entries = append(entries, &ldap.Entry{DN: dn, Attributes: attrs})
+		dir.Groups = append(dir.Groups, repos...)
 	}
 	return
 }
 
-func (h *handler) listUsersCached() (entries []*ldap.Entry, err error) {
-	if h.cache == nil {
-		return h.listUsers()
-	}
-
-	v, err := h.cache.Get(keyUsers)
-	if err == nil {
-		err = gob.NewDecoder(bytes.NewReader(v)).Decode(&entries)
+// listCandidates return entries that may match the filter. Unless the whole directory is already cached,
+// it avoids listing all users and groups when the filter can be translated into a query for a few users.
+// Stale directory is served while it is refreshed in the background
+func (h *handler) listCandidates(f *filter) (entries []*ldap.Entry, err error) {
+	dir, stale, ok := h.getCachedDirectory()
+	if ok && stale {
+		h.refreshDirectoryInBackground()
 	}
-	if err != nil {
-		if entries, err = h.listUsers(); err != nil {
-			return
+	if !ok {
+		if q := h.newUserQuery(f); q != nil {
+			return h.listQueriedUsers(q)
 		}
-		var buf bytes.Buffer
-		if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
-			return entries, nil
-		}
-		if err := h.cache.Set(keyUsers, buf.Bytes(), h.cacheExpire); err != nil {
-			h.logger.Warn("caching_failed").WithFields("error", err)
+		if dir, err = h.refreshDirectory(); err != nil {
+			return
 		}
-		return
 	}
-	return
+	entries = make([]*ldap.Entry, 0, len(dir.Users)+len(dir.Groups))
+	return append(append(entries, dir.Users...), dir.Groups...), nil
 }
 
-// Search return all gitea users and depends on server to filter it
-// only handle 'inetorgperson'
+// Search evaluate the search request against all gitea users and groups
 func (h *handler) Search(boundDN string, searchReq ldap.SearchRequest, conn net.Conn) (res ldap.ServerSearchResult, err error) {
-	if err := h.checkSearchPermission(boundDN, searchReq); err != nil {
+	boundDN = h.canonicalDN(boundDN)
+	if res, ok, err := h.searchRootDSE(searchReq); ok {
+		return res, err
+	}
+	acc, err := h.checkSearchPermission(boundDN, searchReq)
+	if err != nil {
 		h.logger.Error("insufficient_access_right").WithFields("error", err)
 		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultInsufficientAccessRights}, err
 	}
 
-	class, err := ldap.GetFilterObjectClass(searchReq.Filter)
+	paging := getPagingControl(searchReq)
+	if paging != nil && len(paging.Cookie) > 0 {
+		return h.continuePagedSearch(boundDN, searchReq, paging, conn)
+	}
+
+	f, err := parseFilter(searchReq.Filter)
 	if err != nil {
-		h.logger.Error("invalid_object_class").WithFields("error", err)
+		h.logger.Error("invalid_filter").WithFields("filter", searchReq.Filter, "error", err)
 		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultOperationsError},
 			fmt.Errorf("Search Error: error parsing filter: %s", searchReq.Filter)
 	}
-	switch class {
-	case "":
-	case "inetorgperson":
-	default:
-		h.logger.Error("unhandled_object_class").WithFields("object_class", class)
-		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultOperationsError},
-			fmt.Errorf("Search Error: unhandled filter type: %s [%s]", class, searchReq.Filter)
-	}
 
-	entries, err := h.listUsersCached()
+	candidates, err := h.listCandidates(f)
 	if err != nil {
-		h.logger.Error("list_users_failed").WithFields("error", err)
+		h.logger.Error("list_directory_failed").WithFields("error", err)
 		return ldap.ServerSearchResult{ResultCode: ldap.LDAPResultOperationsError}, err
 	}
+	entries, code := searchEntries(acc.authorize(candidates), searchReq, f)
+	if paging != nil {
+		ps := &pagedSearch{boundDN: boundDN, request: pagedRequest(searchReq), entries: entries, code: code}
+		return h.nextPage(conn, ps, paging.PagingSize)
+	}
 	res = ldap.ServerSearchResult{
 		Entries:    entries,
 		Referrals:  []string{},
 		Controls:   []ldap.Control{},
-		ResultCode: ldap.LDAPResultSuccess,
+		ResultCode: code,
 	}
 
 	return
 }
 
 func (h *handler) Close(boundDN string, conn net.Conn) (err error) {
+	h.clearPagedSearches(conn)
 	return nil
 }
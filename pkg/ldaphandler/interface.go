@@ -1,13 +1,23 @@
 package ldaphandler
 
-import "github.com/nmcclain/ldap"
+import (
+	"net"
+	"net/http"
+
+	"github.com/coocood/freecache"
+	"github.com/nmcclain/ldap"
+)
 
 type Interface interface {
 	ldap.Binder
 	ldap.Searcher
 	ldap.Closer
+	http.Handler
+	Compare(boundDN, dn, attribute, value string, conn net.Conn) (ldap.LDAPResultCode, error)
+	ModifyPassword(boundDN, userIdentity, oldPassword, newPassword string, conn net.Conn) (ldap.LDAPResultCode, error)
 }
 
 var (
 	_ Interface = &handler{}
+	_ Cache     = &freecache.Cache{}
 )
@@ -1,16 +1,15 @@
 package ldaphandler
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"net"
 	"testing"
-	"time"
 
 	"code.gitea.io/gitea/models"
 	ldapc "github.com/go-ldap/ldap/v3"
-	"github.com/nmcclain/ldap"
 	"github.com/rucciva/giteaty/pkg/gitea/globals"
+	"github.com/rucciva/giteaty/pkg/ldapserver"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,68 +24,35 @@ func tCreateUser(u models.User) (user *models.User, closer func(), err error) {
 }
 
 type tLDAPServer struct {
-	handler Interface
-
-	quit chan bool
-	done bool
-	port uint16
-	err  error
+	ln     net.Listener
+	cancel context.CancelFunc
 }
 
 func newLDAPTestServer(t *testing.T, handler Interface) (s *tLDAPServer, err error) {
-	s = &tLDAPServer{
-		quit:    make(chan bool),
-		handler: handler,
-		port:    uint16(rand.Intn(65535-1024) + 1024),
+	sv, err := ldapserver.New(handler)
+	if err != nil {
+		return
 	}
-	go s.start(t)
-	err = s.waitConnection()
-	return
-}
-
-func (s *tLDAPServer) start(t *testing.T) {
-	sv := ldap.NewServer()
-	sv.EnforceLDAP = true
-	sv.BindFunc("", s.handler)
-	sv.SearchFunc("", s.handler)
-	sv.QuitChannel(s.quit)
-	for {
-		t.Logf("listenting on :%d", s.port)
-		s.err = sv.ListenAndServe(fmt.Sprintf(":%d", s.port))
-		if s.done {
-			t.Logf("closed")
-			return
-		}
-		t.Logf("listen error: %v", s.err)
-		s.port += 1
+	s = &tLDAPServer{}
+	if s.ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return
 	}
-}
-
-func (s *tLDAPServer) waitConnection() (err error) {
-	maxDelay := time.Second
-	delay := time.Millisecond
-	var conn net.Conn
-	for delay <= maxDelay {
-		conn, err = net.DialTimeout("tcp", fmt.Sprintf(":%d", s.port), time.Second)
-		if conn != nil {
-			conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go func() {
+		if err := sv.Serve(ctx, s.ln); err != nil {
+			t.Logf("serve error: %v", err)
 		}
-		if err == nil {
-			return
-		}
-		<-time.After(delay)
-		delay = delay * 2
-	}
+	}()
 	return
 }
 
 func (s *tLDAPServer) url() string {
-	return fmt.Sprintf("ldap://0.0.0.0:%d", s.port)
+	return fmt.Sprintf("ldap://%s", s.ln.Addr())
 }
 
 func (s *tLDAPServer) close() error {
-	s.done = true
-	close(s.quit)
+	s.cancel()
 	return nil
 }
 
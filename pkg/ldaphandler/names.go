@@ -52,3 +52,34 @@ func (a names) Attributes() (attrs []*ldap.EntryAttribute) {
 	}
 	return
 }
+
+// normalizeDN lowercase the DN and remove spaces surrounding its separators
+func normalizeDN(dn string) string {
+	rdns := strings.Split(strings.ToLower(dn), ",")
+	for i, rdn := range rdns {
+		avas := strings.Split(rdn, "+")
+		for j, ava := range avas {
+			nv := strings.SplitN(ava, "=", 2)
+			for k := range nv {
+				nv[k] = strings.TrimSpace(nv[k])
+			}
+			avas[j] = strings.Join(nv, "=")
+		}
+		rdns[i] = strings.Join(avas, "+")
+	}
+	return strings.Join(rdns, ",")
+}
+
+// parseChildDN return the value of the single attribute RDN of dn, which must be a direct child of parent
+func parseChildDN(dn, attr string, parent ...names) (value string, ok bool) {
+	parents := make([]string, 0, len(parent))
+	for _, p := range parent {
+		parents = append(parents, normalizeDN(p.String()))
+	}
+	rdn, err := getRDN(normalizeDN(dn), parents...)
+	if err != nil || strings.ContainsAny(rdn, ",+") {
+		return
+	}
+	value = strings.TrimPrefix(rdn, strings.ToLower(attr)+"=")
+	return value, value != rdn && value != ""
+}
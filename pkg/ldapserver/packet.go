@@ -0,0 +1,187 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+
+	ber "github.com/nmcclain/asn1-ber"
+	"github.com/nmcclain/ldap"
+)
+
+func encodeResult(messageID uint64, tag uint8, code ldap.LDAPResultCode, message string, controls []ldap.Control, extras ...*ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, ldap.ApplicationMap[tag])
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "resultCode: "))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN: "))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "errorMessage: "))
+	for _, extra := range extras {
+		res.AppendChild(extra)
+	}
+	packet.AppendChild(res)
+
+	if len(controls) > 0 {
+		ctrls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, ctrl := range controls {
+			ctrls.AppendChild(ctrl.Encode())
+		}
+		packet.AppendChild(ctrls)
+	}
+	return packet
+}
+
+func encodeSearchEntry(messageID uint64, entry *ldap.Entry) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes:")
+	for _, attr := range entry.Attributes {
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.Name, "Attribute Name"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Attribute Values")
+		for _, v := range attr.Values {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Attribute Value"))
+		}
+		a.AppendChild(values)
+		attrs.AppendChild(a)
+	}
+	res.AppendChild(attrs)
+	packet.AppendChild(res)
+
+	return packet
+}
+
+func decodeString(p *ber.Packet) string {
+	return string(p.Data.Bytes())
+}
+
+func decodeInt(p *ber.Packet) (int, error) {
+	v, ok := p.Value.(uint64)
+	if !ok {
+		return 0, fmt.Errorf("'%s' is not an integer", p.Description)
+	}
+	return int(v), nil
+}
+
+func decodeSearchRequest(req *ber.Packet, controls []ldap.Control) (searchReq ldap.SearchRequest, err error) {
+	if len(req.Children) != 8 {
+		return searchReq, fmt.Errorf("bad search request: expecting 8 children, got %d", len(req.Children))
+	}
+
+	searchReq.BaseDN = decodeString(req.Children[0])
+	if searchReq.Scope, err = decodeInt(req.Children[1]); err != nil {
+		return
+	}
+	if searchReq.DerefAliases, err = decodeInt(req.Children[2]); err != nil {
+		return
+	}
+	if searchReq.SizeLimit, err = decodeInt(req.Children[3]); err != nil {
+		return
+	}
+	if searchReq.TimeLimit, err = decodeInt(req.Children[4]); err != nil {
+		return
+	}
+	searchReq.TypesOnly, _ = req.Children[5].Value.(bool)
+	if searchReq.Filter, err = decodeFilter(req.Children[6]); err != nil {
+		return
+	}
+	searchReq.Attributes = []string{}
+	for _, attr := range req.Children[7].Children {
+		searchReq.Attributes = append(searchReq.Attributes, decodeString(attr))
+	}
+	searchReq.Controls = controls
+	return
+}
+
+func decodeCompareRequest(req *ber.Packet) (dn, attribute, value string, err error) {
+	if len(req.Children) != 2 || len(req.Children[1].Children) != 2 {
+		return "", "", "", fmt.Errorf("bad compare request: expecting entry and attribute value assertion")
+	}
+	ava := req.Children[1]
+	return decodeString(req.Children[0]), decodeString(ava.Children[0]), decodeString(ava.Children[1]), nil
+}
+
+// decodeFilter convert filter packet into its RFC 4515 string representation. Unlike ldap.DecompileFilter,
+// it keeps every components of a substring filter and escapes the asserted values
+func decodeFilter(p *ber.Packet) (s string, err error) {
+	var sb strings.Builder
+	sb.WriteString("(")
+	switch p.Tag {
+	case ldap.FilterAnd, ldap.FilterOr:
+		sb.WriteString(map[uint8]string{ldap.FilterAnd: "&", ldap.FilterOr: "|"}[p.Tag])
+		for _, child := range p.Children {
+			if s, err = decodeFilter(child); err != nil {
+				return
+			}
+			sb.WriteString(s)
+		}
+
+	case ldap.FilterNot:
+		if len(p.Children) != 1 {
+			return "", fmt.Errorf("bad not filter: expecting 1 child, got %d", len(p.Children))
+		}
+		if s, err = decodeFilter(p.Children[0]); err != nil {
+			return
+		}
+		sb.WriteString("!" + s)
+
+	case ldap.FilterPresent:
+		sb.WriteString(decodeString(p) + "=*")
+
+	case ldap.FilterSubstrings:
+		if len(p.Children) != 2 {
+			return "", fmt.Errorf("bad substrings filter: expecting 2 children, got %d", len(p.Children))
+		}
+		sb.WriteString(decodeString(p.Children[0]) + "=")
+		initial, final := "", ""
+		anys := []string{}
+		for _, sub := range p.Children[1].Children {
+			switch sub.Tag {
+			case ldap.FilterSubstringsInitial:
+				initial = escapeFilterValue(decodeString(sub))
+			case ldap.FilterSubstringsAny:
+				anys = append(anys, escapeFilterValue(decodeString(sub)))
+			case ldap.FilterSubstringsFinal:
+				final = escapeFilterValue(decodeString(sub))
+			}
+		}
+		sb.WriteString(initial + "*")
+		for _, sub := range anys {
+			sb.WriteString(sub + "*")
+		}
+		sb.WriteString(final)
+
+	case ldap.FilterEqualityMatch, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual, ldap.FilterApproxMatch:
+		if len(p.Children) != 2 {
+			return "", fmt.Errorf("bad %s filter: expecting 2 children, got %d", ldap.FilterMap[p.Tag], len(p.Children))
+		}
+		op := map[uint8]string{
+			ldap.FilterEqualityMatch:  "=",
+			ldap.FilterGreaterOrEqual: ">=",
+			ldap.FilterLessOrEqual:    "<=",
+			ldap.FilterApproxMatch:    "~=",
+		}[p.Tag]
+		sb.WriteString(decodeString(p.Children[0]) + op + escapeFilterValue(decodeString(p.Children[1])))
+
+	default:
+		return "", fmt.Errorf("unsupported filter %d", p.Tag)
+	}
+	sb.WriteString(")")
+	return sb.String(), nil
+}
+
+func escapeFilterValue(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&sb, `\%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,62 @@
+package ldapserver
+
+import (
+	"fmt"
+	"net"
+
+	ber "github.com/nmcclain/asn1-ber"
+	"github.com/nmcclain/ldap"
+)
+
+// OIDPasswordModify is the name of RFC 3062 password modify extended operation
+const OIDPasswordModify = "1.3.6.1.4.1.4203.1.11.1"
+
+// PasswordModifier is optionally implemented by Handler to serve password modify extended operation.
+// Empty userIdentity means the bound identity
+type PasswordModifier interface {
+	ModifyPassword(boundDN, userIdentity, oldPassword, newPassword string, conn net.Conn) (ldap.LDAPResultCode, error)
+}
+
+// decodePasswordModifyRequest decode the optional PasswdModifyRequestValue of the extended request
+func decodePasswordModifyRequest(req *ber.Packet) (userIdentity, oldPassword, newPassword string, err error) {
+	if len(req.Children) < 2 {
+		return
+	}
+	value := ber.DecodePacket(req.Children[1].Data.Bytes())
+	if value == nil || value.Tag != ber.TagSequence {
+		return "", "", "", fmt.Errorf("bad password modify request: expecting sequence")
+	}
+	for _, child := range value.Children {
+		switch child.Tag {
+		case 0:
+			userIdentity = decodeString(child)
+		case 1:
+			oldPassword = decodeString(child)
+		case 2:
+			newPassword = decodeString(child)
+		default:
+			return "", "", "", fmt.Errorf("bad password modify request: unknown field %d", child.Tag)
+		}
+	}
+	return
+}
+
+func (s *Server) modifyPassword(sess *session, messageID uint64, req *ber.Packet, pm PasswordModifier) (err error) {
+	if s.requireTLS && !isTLS(sess.conn) {
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse,
+			ldap.LDAPResultConfidentialityRequired, "password modify requires a TLS protected connection", nil))
+	}
+	userIdentity, oldPassword, newPassword, err := decodePasswordModifyRequest(req)
+	if err != nil {
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError, err.Error(), nil))
+	}
+
+	code, err := pm.ModifyPassword(sess.boundDN, userIdentity, oldPassword, newPassword, sess.conn)
+	if err != nil {
+		if code == ldap.LDAPResultSuccess {
+			code = ldap.LDAPResultOperationsError
+		}
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse, code, err.Error(), nil))
+	}
+	return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse, code, "", nil))
+}
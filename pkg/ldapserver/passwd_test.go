@@ -0,0 +1,58 @@
+package ldapserver
+
+import (
+	"fmt"
+	"testing"
+
+	ldapc "github.com/go-ldap/ldap/v3"
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordModify(t *testing.T) {
+	h := &tHandler{}
+	url, closer := tServe(t, h)
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.Bind("uid=user,dc=domain,dc=com", "secret"))
+
+	_, err = l.PasswordModify(ldapc.NewPasswordModifyRequest("", "secret", "new-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uid=user,dc=domain,dc=com", "", "secret", "new-secret"}, h.passwdArgs)
+
+	_, err = l.PasswordModify(ldapc.NewPasswordModifyRequest("uid=other,dc=domain,dc=com", "", "new-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uid=user,dc=domain,dc=com", "uid=other,dc=domain,dc=com", "", "new-secret"}, h.passwdArgs)
+
+	h.passwdCode, h.passwdErr = ldap.LDAPResultConstraintViolation, fmt.Errorf("too short")
+	_, err = l.PasswordModify(ldapc.NewPasswordModifyRequest("", "secret", "new"))
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultConstraintViolation), "should return constraint violation")
+}
+
+func TestPasswordModifyRequireTLS(t *testing.T) {
+	url, closer := tServe(t, &tHandler{}, WithRequireTLS(true))
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.PasswordModify(ldapc.NewPasswordModifyRequest("uid=user,dc=domain,dc=com", "secret", "new-secret"))
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultConfidentialityRequired), "should require tls")
+}
+
+func TestPasswordModifyUnsupported(t *testing.T) {
+	url, closer := tServe(t, struct{ Handler }{&tHandler{}})
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.PasswordModify(ldapc.NewPasswordModifyRequest("", "secret", "new-secret"))
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultProtocolError), "should refuse password modify")
+}
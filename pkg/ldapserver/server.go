@@ -0,0 +1,310 @@
+// Package ldapserver serves ldaphandler, which evaluates search filter, scope, attributes, and size limit itself.
+// The server shipped with github.com/nmcclain/ldap can not do so: it sends every entry returned by the handler
+// followed by a success result, so sizeLimitExceeded can only be reported by dropping all entries, and it either
+// re-applies its own filter evaluation to the entries or none of the search request at all
+package ldapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"git.rucciva.one/rucciva/log"
+	ber "github.com/nmcclain/asn1-ber"
+	"github.com/nmcclain/ldap"
+)
+
+// Handler is the set of operations served by Server
+type Handler interface {
+	ldap.Binder
+	ldap.Searcher
+	ldap.Closer
+}
+
+// Comparer is optionally implemented by Handler to serve compare requests. Compare must return
+// ldap.LDAPResultCompareTrue or ldap.LDAPResultCompareFalse when the assertion can be evaluated
+type Comparer interface {
+	Compare(boundDN, dn, attribute, value string, conn net.Conn) (ldap.LDAPResultCode, error)
+}
+
+type option = func(s *Server) error
+
+func Options() []option {
+	return make([]func(s *Server) error, 0, 3)
+}
+
+// WithTLS enable LDAPS listener and StartTLS extended operation using the certificate and key files.
+// The files are reloaded when they are modified
+func WithTLS(certFile, keyFile string) option {
+	return func(s *Server) (err error) {
+		r, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return
+		}
+		s.tlsConfig = &tls.Config{
+			GetCertificate: r.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		return
+	}
+}
+
+// WithRequireTLS refuse simple binds on connections that are not protected by TLS
+func WithRequireTLS(required bool) option {
+	return func(s *Server) (err error) {
+		s.requireTLS = required
+		return
+	}
+}
+
+func WithLogger(l log.PLogger) option {
+	return func(s *Server) (err error) {
+		s.logger = l
+		return
+	}
+}
+
+// Server is a minimal LDAPv3 server. Unlike the server shipped with github.com/nmcclain/ldap,
+// it does not post-process search results and it sends back the result code and
+// controls returned by the handler
+type Server struct {
+	handler Handler
+
+	tlsConfig  *tls.Config
+	requireTLS bool
+
+	logger log.PLogger
+}
+
+func New(h Handler, opts ...option) (s *Server, err error) {
+	s = &Server{
+		handler: h,
+		logger:  log.GetPGlobal(),
+	}
+	for _, opt := range opts {
+		if err = opt(s); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (s *Server) ListenAndServe(ctx context.Context, addr string) (err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	return s.Serve(ctx, ln)
+}
+
+func (s *Server) ListenAndServeTLS(ctx context.Context, addr string) (err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	return s.ServeTLS(ctx, ln)
+}
+
+// ServeTLS accept TLS connections until ctx is done
+func (s *Server) ServeTLS(ctx context.Context, ln net.Listener) (err error) {
+	if s.tlsConfig == nil {
+		ln.Close()
+		return fmt.Errorf("tls is not configured")
+	}
+	return s.Serve(ctx, tls.NewListener(ln, s.tlsConfig))
+}
+
+// Serve accept connections until ctx is done
+func (s *Server) Serve(ctx context.Context, ln net.Listener) (err error) {
+	go func() { <-ctx.Done(); ln.Close() }()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// session is the state of a client connection
+type session struct {
+	conn    net.Conn
+	boundDN string
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	sess := &session{conn: conn}
+	defer func() {
+		if err := s.handler.Close(sess.boundDN, sess.conn); err != nil {
+			s.logger.Warn("close_handler_failed").WithFields("error", err)
+		}
+		sess.conn.Close()
+	}()
+
+	for {
+		packet, err := ber.ReadPacket(sess.conn)
+		if err != nil {
+			if err != io.EOF {
+				s.logger.Warn("read_packet_failed").WithFields("error", err)
+			}
+			return
+		}
+
+		if err = s.handle(sess, packet); err != nil {
+			if err != errUnbind {
+				s.logger.Error("handle_packet_failed").WithFields("error", err)
+			}
+			return
+		}
+	}
+}
+
+var errUnbind = errors.New("unbind")
+
+func (s *Server) handle(sess *session, packet *ber.Packet) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed packet: %v", r)
+		}
+	}()
+
+	if len(packet.Children) < 2 {
+		return fmt.Errorf("malformed packet: expecting at least 2 children, got %d", len(packet.Children))
+	}
+	messageID, ok := packet.Children[0].Value.(uint64)
+	if !ok {
+		return fmt.Errorf("malformed message id")
+	}
+	req := packet.Children[1]
+	if req.ClassType != ber.ClassApplication {
+		return fmt.Errorf("malformed request: invalid class type %d", req.ClassType)
+	}
+	controls := []ldap.Control{}
+	if len(packet.Children) > 2 {
+		for _, child := range packet.Children[2].Children {
+			controls = append(controls, ldap.DecodeControl(child))
+		}
+	}
+
+	switch req.Tag {
+	case ldap.ApplicationBindRequest:
+		sess.boundDN = ""
+		if s.requireTLS && !isTLS(sess.conn) {
+			return send(sess.conn, encodeResult(messageID, ldap.ApplicationBindResponse,
+				ldap.LDAPResultConfidentialityRequired, "bind requires a TLS protected connection", nil))
+		}
+		code := ldap.HandleBindRequest(req, map[string]ldap.Binder{"": s.handler}, sess.conn)
+		if code == ldap.LDAPResultSuccess {
+			sess.boundDN = decodeString(req.Children[1])
+		}
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationBindResponse, code, "", nil))
+
+	case ldap.ApplicationSearchRequest:
+		return s.search(sess, messageID, req, controls)
+
+	case ldap.ApplicationUnbindRequest:
+		return errUnbind
+
+	case ldap.ApplicationAbandonRequest:
+		return nil
+
+	case ldap.ApplicationExtendedRequest:
+		name := ""
+		if len(req.Children) > 0 {
+			name = decodeString(req.Children[0])
+		}
+		if name == OIDStartTLS && s.tlsConfig != nil {
+			return s.startTLS(sess, messageID)
+		}
+		if pm, ok := s.handler.(PasswordModifier); ok && name == OIDPasswordModify {
+			return s.modifyPassword(sess, messageID, req, pm)
+		}
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse,
+			ldap.LDAPResultProtocolError, "unsupported extended operation", nil))
+
+	case ldap.ApplicationCompareRequest:
+		if c, ok := s.handler.(Comparer); ok {
+			return s.compare(sess, messageID, req, c)
+		}
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationCompareResponse,
+			ldap.LDAPResultUnwillingToPerform, "unsupported operation: "+ldap.ApplicationMap[req.Tag], nil))
+
+	case ldap.ApplicationModifyRequest, ldap.ApplicationAddRequest, ldap.ApplicationDelRequest,
+		ldap.ApplicationModifyDNRequest:
+		return send(sess.conn, encodeResult(messageID, req.Tag+1,
+			ldap.LDAPResultUnwillingToPerform, "unsupported operation: "+ldap.ApplicationMap[req.Tag], nil))
+	}
+	return fmt.Errorf("unknown operation %d", req.Tag)
+}
+
+// startTLS upgrade the connection to TLS after responding to StartTLS extended request
+func (s *Server) startTLS(sess *session, messageID uint64) (err error) {
+	responseName := ber.NewString(ber.ClassContext, ber.TypePrimitive, 10, OIDStartTLS, "responseName")
+	if isTLS(sess.conn) {
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse,
+			ldap.LDAPResultOperationsError, "tls is already established", nil, responseName))
+	}
+	err = send(sess.conn, encodeResult(messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "", nil, responseName))
+	if err != nil {
+		return
+	}
+
+	conn := tls.Server(sess.conn, s.tlsConfig)
+	if err = conn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake failed: %w", err)
+	}
+	sess.conn = conn
+	return
+}
+
+func (s *Server) search(sess *session, messageID uint64, req *ber.Packet, controls []ldap.Control) (err error) {
+	conn := sess.conn
+	searchReq, err := decodeSearchRequest(req, controls)
+	if err != nil {
+		return send(conn, encodeResult(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, err.Error(), nil))
+	}
+
+	res, err := s.handler.Search(sess.boundDN, searchReq, conn)
+	if err != nil {
+		code := res.ResultCode
+		if code == ldap.LDAPResultSuccess {
+			code = ldap.LDAPResultOperationsError
+		}
+		return send(conn, encodeResult(messageID, ldap.ApplicationSearchResultDone, code, err.Error(), nil))
+	}
+
+	for _, entry := range res.Entries {
+		if err = send(conn, encodeSearchEntry(messageID, entry)); err != nil {
+			return
+		}
+	}
+	return send(conn, encodeResult(messageID, ldap.ApplicationSearchResultDone, res.ResultCode, "", res.Controls))
+}
+
+func (s *Server) compare(sess *session, messageID uint64, req *ber.Packet, c Comparer) (err error) {
+	dn, attribute, value, err := decodeCompareRequest(req)
+	if err != nil {
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationCompareResponse, ldap.LDAPResultProtocolError, err.Error(), nil))
+	}
+
+	code, err := c.Compare(sess.boundDN, dn, attribute, value, sess.conn)
+	if err != nil {
+		if code == ldap.LDAPResultSuccess || code == ldap.LDAPResultCompareTrue || code == ldap.LDAPResultCompareFalse {
+			code = ldap.LDAPResultOperationsError
+		}
+		return send(sess.conn, encodeResult(messageID, ldap.ApplicationCompareResponse, code, err.Error(), nil))
+	}
+	return send(sess.conn, encodeResult(messageID, ldap.ApplicationCompareResponse, code, "", nil))
+}
+
+func send(conn net.Conn, packet *ber.Packet) (err error) {
+	_, err = conn.Write(packet.Bytes())
+	return
+}
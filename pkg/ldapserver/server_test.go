@@ -0,0 +1,204 @@
+package ldapserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	ldapc "github.com/go-ldap/ldap/v3"
+	"github.com/nmcclain/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tHandler struct {
+	boundDN   string
+	searchReq ldap.SearchRequest
+	searchRes ldap.ServerSearchResult
+	searchErr error
+
+	compareArgs []string
+	compareCode ldap.LDAPResultCode
+	compareErr  error
+
+	passwdArgs []string
+	passwdCode ldap.LDAPResultCode
+	passwdErr  error
+}
+
+func (h *tHandler) Bind(bindDN, pw string, conn net.Conn) (ldap.LDAPResultCode, error) {
+	if pw != "secret" {
+		return ldap.LDAPResultInvalidCredentials, nil
+	}
+	return ldap.LDAPResultSuccess, nil
+}
+
+func (h *tHandler) Search(boundDN string, req ldap.SearchRequest, conn net.Conn) (ldap.ServerSearchResult, error) {
+	h.boundDN, h.searchReq = boundDN, req
+	return h.searchRes, h.searchErr
+}
+
+func (h *tHandler) Compare(boundDN, dn, attribute, value string, conn net.Conn) (ldap.LDAPResultCode, error) {
+	h.compareArgs = []string{boundDN, dn, attribute, value}
+	return h.compareCode, h.compareErr
+}
+
+func (h *tHandler) ModifyPassword(boundDN, userIdentity, oldPassword, newPassword string, conn net.Conn) (ldap.LDAPResultCode, error) {
+	h.passwdArgs = []string{boundDN, userIdentity, oldPassword, newPassword}
+	return h.passwdCode, h.passwdErr
+}
+
+func (h *tHandler) Close(boundDN string, conn net.Conn) error {
+	return nil
+}
+
+func tServe(t *testing.T, h Handler, opts ...option) (url string, closer func()) {
+	s, err := New(h, opts...)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = s.Serve(ctx, ln) }()
+	return fmt.Sprintf("ldap://%s", ln.Addr()), cancel
+}
+
+func TestBind(t *testing.T) {
+	h := &tHandler{}
+	url, closer := tServe(t, h)
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	err = l.Bind("uid=user,dc=domain,dc=com", "invalid")
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultInvalidCredentials), "should return invalid credentials")
+
+	_, err = l.Search(ldapc.NewSearchRequest("dc=domain,dc=com", ldapc.ScopeWholeSubtree, ldapc.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "", h.boundDN, "failed bind should be anonymous")
+
+	err = l.Bind("uid=user,dc=domain,dc=com", "secret")
+	require.NoError(t, err)
+
+	_, err = l.Search(ldapc.NewSearchRequest("dc=domain,dc=com", ldapc.ScopeWholeSubtree, ldapc.NeverDerefAliases, 0, 0, false, "(uid=*)", nil, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "uid=user,dc=domain,dc=com", h.boundDN, "search should be done using bound dn")
+}
+
+func TestSearch(t *testing.T) {
+	h := &tHandler{}
+	url, closer := tServe(t, h)
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	entries := []*ldap.Entry{
+		{DN: "uid=user,dc=domain,dc=com", Attributes: []*ldap.EntryAttribute{
+			{Name: "uid", Values: []string{"user"}},
+			{Name: "memberOf", Values: []string{"cn=a,dc=domain,dc=com", "cn=b,dc=domain,dc=com"}},
+		}},
+		{DN: "uid=user1,dc=domain,dc=com", Attributes: []*ldap.EntryAttribute{
+			{Name: "uid", Values: []string{"user1"}},
+		}},
+	}
+	h.searchRes = ldap.ServerSearchResult{Entries: entries, ResultCode: ldap.LDAPResultSuccess}
+
+	filters := map[string]string{
+		"(uid=*)":                          "(uid=*)",
+		"(&(uid=a*b*c)(!(cn=*d)))":         "(&(uid=a*b*c)(!(cn=*d)))",
+		"(|(cn=*a*b*)(uid>=1)(uid<=2))":    "(|(cn=*a*b*)(uid>=1)(uid<=2))",
+		`(cn=\2a\28\29\5c)`:                `(cn=\2a\28\29\5c)`,
+		`(cn=*\2a*)`:                       `(cn=*\2a*)`,
+		"(&(objectClass=person)(uid~=ab))": "(&(objectClass=person)(uid~=ab))",
+	}
+	for filter, expected := range filters {
+		req := ldapc.NewSearchRequest(
+			"dc=domain,dc=com", ldapc.ScopeSingleLevel, ldapc.NeverDerefAliases, 10, 5, true,
+			filter, []string{"uid", "mail"}, nil,
+		)
+		res, err := l.Search(req)
+		require.NoError(t, err)
+		require.Len(t, res.Entries, len(entries))
+		for i, entry := range res.Entries {
+			assert.Equal(t, entries[i].DN, entry.DN)
+			for j, attr := range entry.Attributes {
+				assert.Equal(t, entries[i].Attributes[j].Name, attr.Name)
+				assert.Equal(t, entries[i].Attributes[j].Values, attr.Values)
+			}
+		}
+		assert.Equal(t, ldap.SearchRequest{
+			BaseDN: "dc=domain,dc=com", Scope: ldap.ScopeSingleLevel, DerefAliases: ldap.NeverDerefAliases,
+			SizeLimit: 10, TimeLimit: 5, TypesOnly: true, Filter: expected, Attributes: []string{"uid", "mail"},
+			Controls: []ldap.Control{},
+		}, h.searchReq)
+	}
+}
+
+func TestSearchResultCode(t *testing.T) {
+	h := &tHandler{}
+	url, closer := tServe(t, h)
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	req := ldapc.NewSearchRequest("dc=domain,dc=com", ldapc.ScopeWholeSubtree, ldapc.NeverDerefAliases, 1, 0, false, "(uid=*)", nil, nil)
+
+	h.searchRes = ldap.ServerSearchResult{
+		Entries:    []*ldap.Entry{{DN: "uid=user,dc=domain,dc=com"}},
+		ResultCode: ldap.LDAPResultSizeLimitExceeded,
+	}
+	_, err = l.Search(req)
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultSizeLimitExceeded), "should return size limit exceeded")
+
+	h.searchRes, h.searchErr = ldap.ServerSearchResult{ResultCode: ldap.LDAPResultInsufficientAccessRights}, fmt.Errorf("denied")
+	_, err = l.Search(req)
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultInsufficientAccessRights), "should return insufficient access rights")
+
+	h.searchRes, h.searchErr = ldap.ServerSearchResult{}, nil
+	_, err = l.Search(req)
+	assert.NoError(t, err, "connection should still be usable after failed search")
+}
+
+func TestCompare(t *testing.T) {
+	h := &tHandler{}
+	url, closer := tServe(t, h)
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.Bind("uid=user,dc=domain,dc=com", "secret"))
+
+	h.compareCode = ldap.LDAPResultCompareTrue
+	ok, err := l.Compare("cn=org,dc=domain,dc=com", "member", "uid=user,dc=domain,dc=com")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"uid=user,dc=domain,dc=com", "cn=org,dc=domain,dc=com", "member", "uid=user,dc=domain,dc=com"}, h.compareArgs)
+
+	h.compareCode = ldap.LDAPResultCompareFalse
+	ok, err = l.Compare("cn=org,dc=domain,dc=com", "member", "uid=other,dc=domain,dc=com")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	h.compareCode, h.compareErr = ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("denied")
+	_, err = l.Compare("cn=org,dc=domain,dc=com", "member", "uid=user,dc=domain,dc=com")
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultInsufficientAccessRights), "should return insufficient access rights")
+}
+
+func TestCompareUnsupported(t *testing.T) {
+	url, closer := tServe(t, struct{ Handler }{&tHandler{}})
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Compare("cn=org,dc=domain,dc=com", "member", "uid=user,dc=domain,dc=com")
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultUnwillingToPerform), "should return unwilling to perform")
+}
@@ -0,0 +1,85 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// OIDStartTLS is the name of StartTLS extended operation as defined by RFC 4511
+const OIDStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// certReloader load certificate and its key from files, and reload them whenever one of the files is modified
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (r *certReloader, err error) {
+	r = &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err = r.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// lastModified return the latest modification time of both files
+func (r *certReloader) lastModified() (t time.Time, err error) {
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return t, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return
+}
+
+func (r *certReloader) load() (cert *tls.Certificate, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, fmt.Errorf("stat certificate failed: %w", err)
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate failed: %w", err)
+	}
+	r.cert, r.modTime = &c, modTime
+	return r.cert, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The previously loaded certificate is kept
+// when the files are being replaced and can not be loaded yet
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := r.load()
+	if err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.cert == nil {
+			return nil, err
+		}
+		return r.cert, nil
+	}
+	return cert, nil
+}
+
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
@@ -0,0 +1,130 @@
+package ldapserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ldapc "github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tWriteCert(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func tTLSOption(t *testing.T) (certFile, keyFile string, opt option, cleanup func()) {
+	dir, err := ioutil.TempDir("", "ldapserver")
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	tWriteCert(t, certFile, keyFile, "first", time.Now().Add(-time.Minute))
+	return certFile, keyFile, WithTLS(certFile, keyFile), func() { os.RemoveAll(dir) }
+}
+
+func tServeTLS(t *testing.T, h Handler, opts ...option) (url string, closer func()) {
+	s, err := New(h, opts...)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _ = s.ServeTLS(ctx, ln) }()
+	return fmt.Sprintf("ldaps://%s", ln.Addr()), cancel
+}
+
+var tInsecure = ldapc.DialWithTLSConfig(&tls.Config{InsecureSkipVerify: true})
+
+func TestLDAPS(t *testing.T) {
+	_, _, opt, cleanup := tTLSOption(t)
+	defer cleanup()
+	url, closer := tServeTLS(t, &tHandler{}, opt, WithRequireTLS(true))
+	defer closer()
+
+	l, err := ldapc.DialURL(url, tInsecure)
+	require.NoError(t, err)
+	defer l.Close()
+	assert.NoError(t, l.Bind("uid=user,dc=domain,dc=com", "secret"))
+}
+
+func TestStartTLS(t *testing.T) {
+	_, _, opt, cleanup := tTLSOption(t)
+	defer cleanup()
+	url, closer := tServe(t, &tHandler{}, opt, WithRequireTLS(true))
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	err = l.Bind("uid=user,dc=domain,dc=com", "secret")
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultConfidentialityRequired), "should refuse bind without tls")
+
+	require.NoError(t, l.StartTLS(&tls.Config{InsecureSkipVerify: true}))
+	assert.NoError(t, l.Bind("uid=user,dc=domain,dc=com", "secret"))
+}
+
+func TestStartTLSNotConfigured(t *testing.T) {
+	url, closer := tServe(t, &tHandler{})
+	defer closer()
+
+	l, err := ldapc.DialURL(url)
+	require.NoError(t, err)
+	defer l.Close()
+
+	err = l.StartTLS(&tls.Config{InsecureSkipVerify: true})
+	assert.True(t, ldapc.IsErrorWithCode(err, ldapc.LDAPResultProtocolError), "should refuse starttls")
+}
+
+func TestCertReload(t *testing.T) {
+	certFile, keyFile, opt, cleanup := tTLSOption(t)
+	defer cleanup()
+	url, closer := tServeTLS(t, &tHandler{}, opt)
+	defer closer()
+
+	commonName := func() string {
+		l, err := ldapc.DialURL(url, tInsecure)
+		require.NoError(t, err)
+		defer l.Close()
+		state, ok := l.TLSConnectionState()
+		require.True(t, ok)
+		return state.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", commonName())
+
+	tWriteCert(t, certFile, keyFile, "second", time.Now())
+	assert.Equal(t, "second", commonName(), "should serve the new certificate")
+
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+	require.NoError(t, os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	assert.Equal(t, "second", commonName(), "should keep the last valid certificate")
+}
@@ -0,0 +1,339 @@
+// Package rediscache is a minimal cache client speaking the Redis serialization protocol (RESP), usable with
+// Redis and protocol compatible servers such as KeyDB or Dragonfly
+package rediscache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the key does not exist
+var ErrNotFound = errors.New("rediscache: key not found")
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return "rediscache: " + string(e)
+}
+
+type option = func(c *Client) error
+
+func Options() []option {
+	return make([]func(c *Client) error, 0, 4)
+}
+
+// WithPassword authenticate every connection using AUTH command
+func WithPassword(password string) option {
+	return func(c *Client) (err error) {
+		c.password = password
+		return
+	}
+}
+
+// WithDB select the logical database of every connection
+func WithDB(db int) option {
+	return func(c *Client) (err error) {
+		c.db = db
+		return
+	}
+}
+
+// WithPrefix prepend the prefix to every key, so that multiple applications can share a server
+func WithPrefix(prefix string) option {
+	return func(c *Client) (err error) {
+		c.prefix = prefix
+		return
+	}
+}
+
+// WithTimeout set the timeout of dialing and of every command
+func WithTimeout(timeout time.Duration) option {
+	return func(c *Client) (err error) {
+		c.timeout = timeout
+		return
+	}
+}
+
+// WithMaxIdleConns set the number of idle connections kept for reuse
+func WithMaxIdleConns(n int) option {
+	return func(c *Client) (err error) {
+		if n < 1 {
+			return fmt.Errorf("max idle connections must be positive")
+		}
+		c.idle = make(chan *conn, n)
+		return
+	}
+}
+
+// Client is safe for concurrent use. Its Get, Set, and Del have the same signature as freecache.Cache, while
+// GetMulti and SetMulti read or write multiple keys in a single round trip
+type Client struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func New(addr string, opts ...option) (c *Client, err error) {
+	c = &Client{
+		addr:    addr,
+		timeout: 5 * time.Second,
+		idle:    make(chan *conn, 8),
+	}
+	for _, opt := range opts {
+		if err = opt(c); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *Client) Get(key []byte) (value []byte, err error) {
+	reply, err := c.do([]byte("GET"), c.key(key))
+	if err != nil {
+		return
+	}
+	if reply == nil {
+		return nil, ErrNotFound
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rediscache: unexpected reply %v", reply)
+	}
+	return
+}
+
+// Set store the value, which never expires when expireSeconds is not positive
+func (c *Client) Set(key, value []byte, expireSeconds int) (err error) {
+	args := [][]byte{[]byte("SET"), c.key(key), value}
+	if expireSeconds > 0 {
+		args = append(args, []byte("EX"), []byte(strconv.Itoa(expireSeconds)))
+	}
+	_, err = c.do(args...)
+	return
+}
+
+// GetMulti retrieve the values of every key using a single MGET. Values of missing keys are nil
+func (c *Client) GetMulti(keys [][]byte) (values [][]byte, err error) {
+	if len(keys) == 0 {
+		return
+	}
+	args := make([][]byte, 0, len(keys)+1)
+	args = append(args, []byte("MGET"))
+	for _, key := range keys {
+		args = append(args, c.key(key))
+	}
+	reply, err := c.do(args...)
+	if err != nil {
+		return
+	}
+	arr, ok := reply.([]interface{})
+	if !ok || len(arr) != len(keys) {
+		return nil, fmt.Errorf("rediscache: unexpected reply %v", reply)
+	}
+	values = make([][]byte, len(arr))
+	for i, v := range arr {
+		values[i], _ = v.([]byte)
+	}
+	return
+}
+
+// SetMulti store every value by pipelining SET commands in a single round trip
+func (c *Client) SetMulti(keys, values [][]byte, expireSeconds int) (err error) {
+	if len(keys) != len(values) {
+		return fmt.Errorf("rediscache: %d keys but %d values", len(keys), len(values))
+	}
+	cmds := make([][][]byte, 0, len(keys))
+	for i, key := range keys {
+		args := [][]byte{[]byte("SET"), c.key(key), values[i]}
+		if expireSeconds > 0 {
+			args = append(args, []byte("EX"), []byte(strconv.Itoa(expireSeconds)))
+		}
+		cmds = append(cmds, args)
+	}
+	_, err = c.pipeline(cmds...)
+	return
+}
+
+func (c *Client) Del(key []byte) (affected bool) {
+	reply, err := c.do([]byte("DEL"), c.key(key))
+	if err != nil {
+		return
+	}
+	n, _ := reply.(int64)
+	return n > 0
+}
+
+// Close close idle connections
+func (c *Client) Close() (err error) {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) key(key []byte) []byte {
+	return append([]byte(c.prefix), key...)
+}
+
+func (c *Client) dial() (cn *conn, err error) {
+	nc, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return
+	}
+	cn = &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err = c.roundTrip(cn, [][]byte{[]byte("AUTH"), []byte(c.password)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err = c.roundTrip(cn, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(c.db))}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return
+}
+
+func (c *Client) do(args ...[]byte) (reply interface{}, err error) {
+	replies, err := c.pipeline(args)
+	if err != nil {
+		return
+	}
+	return replies[0], nil
+}
+
+// pipeline send every command before reading their replies
+func (c *Client) pipeline(cmds ...[][]byte) (replies []interface{}, err error) {
+	if len(cmds) == 0 {
+		return
+	}
+	var cn *conn
+	select {
+	case cn = <-c.idle:
+	default:
+		if cn, err = c.dial(); err != nil {
+			return
+		}
+	}
+
+	replies, err = c.roundTrip(cn, cmds...)
+	if _, ok := err.(Error); err != nil && !ok {
+		cn.Close()
+		return
+	}
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+	return
+}
+
+// roundTrip return the reply of every command. Error replies do not stop reading the remaining replies,
+// the first one is returned as err
+func (c *Client) roundTrip(cn *conn, cmds ...[][]byte) (replies []interface{}, err error) {
+	if c.timeout > 0 {
+		if err = cn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return
+		}
+	}
+	var b []byte
+	for _, args := range cmds {
+		b = append(b, encodeCommand(args...)...)
+	}
+	if _, err = cn.Write(b); err != nil {
+		return
+	}
+	replies = make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, rerr := readReply(cn.r)
+		if _, ok := rerr.(Error); rerr != nil && !ok {
+			return nil, rerr
+		}
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+		replies[i] = reply
+	}
+	return
+}
+
+func encodeCommand(args ...[]byte) []byte {
+	b := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b = append(b, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+// readReply return string for simple string, Error for error, int64 for integer, []byte or nil for bulk string,
+// and []interface{} or nil for array
+func readReply(r *bufio.Reader) (reply interface{}, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("rediscache: malformed reply %q", line)
+	}
+	typ, data := line[0], line[1:len(line)-2]
+
+	switch typ {
+	case '+':
+		return data, nil
+
+	case '-':
+		return nil, Error(data)
+
+	case ':':
+		return strconv.ParseInt(data, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(data)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+
+	case '*':
+		n, err := strconv.Atoi(data)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("rediscache: unknown reply type %q", typ)
+}
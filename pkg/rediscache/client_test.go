@@ -0,0 +1,207 @@
+package rediscache
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tServer is an in-memory stand-in of redis server supporting the commands used by Client
+type tServer struct {
+	ln       net.Listener
+	password string
+
+	mu     sync.Mutex
+	values map[string][]byte
+	expire map[string]time.Time
+	dials  int
+}
+
+func newTServer(t *testing.T, password string) *tServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &tServer{ln: ln, password: password, values: map[string][]byte{}, expire: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.dials++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *tServer) has(m string, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m == "expire" {
+		_, ok := s.expire[key]
+		return ok
+	}
+	_, ok := s.values[key]
+	return ok
+}
+
+func (s *tServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		req, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args, _ := req.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		cmd := strings.ToUpper(string(args[0].([]byte)))
+		if cmd == "AUTH" {
+			if authenticated = string(args[1].([]byte)) == s.password; !authenticated {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+			continue
+		}
+		if !authenticated {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		conn.Write(s.exec(cmd, args[1:]))
+	}
+}
+
+func (s *tServer) exec(cmd string, args []interface{}) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	get := func(key string) []byte {
+		v, ok := s.values[key]
+		if exp, ok := s.expire[key]; ok && time.Now().After(exp) {
+			delete(s.values, key)
+			return []byte("$-1\r\n")
+		}
+		if !ok {
+			return []byte("$-1\r\n")
+		}
+		return []byte("$" + strconv.Itoa(len(v)) + "\r\n" + string(v) + "\r\n")
+	}
+	switch cmd {
+	case "SELECT":
+		return []byte("+OK\r\n")
+	case "GET":
+		return get(string(args[0].([]byte)))
+	case "MGET":
+		b := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+		for _, arg := range args {
+			b = append(b, get(string(arg.([]byte)))...)
+		}
+		return b
+	case "SET":
+		key := string(args[0].([]byte))
+		s.values[key] = args[1].([]byte)
+		delete(s.expire, key)
+		if len(args) == 4 {
+			sec, _ := strconv.Atoi(string(args[3].([]byte)))
+			s.expire[key] = time.Now().Add(time.Duration(sec) * time.Second)
+		}
+		return []byte("+OK\r\n")
+	case "DEL":
+		key := string(args[0].([]byte))
+		if _, ok := s.values[key]; !ok {
+			return []byte(":0\r\n")
+		}
+		delete(s.values, key)
+		return []byte(":1\r\n")
+	}
+	return []byte("-ERR unknown command '" + cmd + "'\r\n")
+}
+
+func TestClient(t *testing.T) {
+	s := newTServer(t, "secret")
+	defer s.ln.Close()
+
+	c, err := New(s.ln.Addr().String(), WithPassword("secret"), WithDB(1), WithPrefix("giteaty:"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Get([]byte("key"))
+	assert.Equal(t, ErrNotFound, err)
+
+	value := []byte("binary\r\n\x00value")
+	require.NoError(t, c.Set([]byte("key"), value, 60))
+	v, err := c.Get([]byte("key"))
+	require.NoError(t, err)
+	assert.Equal(t, value, v)
+	assert.True(t, s.has("values", "giteaty:key"), "key should be prefixed")
+	assert.True(t, s.has("expire", "giteaty:key"), "key should expire")
+
+	require.NoError(t, c.Set([]byte("persistent"), []byte{}, 0))
+	assert.False(t, s.has("expire", "giteaty:persistent"), "key should not expire")
+	v, err = c.Get([]byte("persistent"))
+	require.NoError(t, err)
+	assert.Empty(t, v)
+
+	assert.True(t, c.Del([]byte("key")))
+	assert.False(t, c.Del([]byte("key")))
+	_, err = c.Get([]byte("key"))
+	assert.Equal(t, ErrNotFound, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, 1, s.dials, "connection should be reused")
+}
+
+func TestClientMulti(t *testing.T) {
+	s := newTServer(t, "")
+	defer s.ln.Close()
+
+	c, err := New(s.ln.Addr().String(), WithPrefix("giteaty:"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	require.NoError(t, c.SetMulti(keys[:2], [][]byte{[]byte("1"), {}}, 60))
+	assert.True(t, s.has("expire", "giteaty:b"), "keys should expire")
+
+	values, err := c.GetMulti(keys)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), {}, nil}, values, "missing key should have nil value")
+	values, err = c.GetMulti(nil)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	assert.Error(t, c.SetMulti(keys, nil, 0), "should reject keys without values")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, 1, s.dials, "connection should be reused")
+}
+
+func TestClientErrors(t *testing.T) {
+	s := newTServer(t, "secret")
+	defer s.ln.Close()
+
+	c, err := New(s.ln.Addr().String(), WithPassword("invalid"))
+	require.NoError(t, err)
+	_, err = c.Get([]byte("key"))
+	assert.IsType(t, Error(""), err, "should return error reply")
+
+	c, err = New(s.ln.Addr().String())
+	require.NoError(t, err)
+	err = c.Set([]byte("key"), []byte("value"), 0)
+	assert.IsType(t, Error(""), err, "should return error reply")
+
+	_, err = New(s.ln.Addr().String(), WithMaxIdleConns(0))
+	assert.Error(t, err, "should reject non positive idle connections")
+}